@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+)
+
+const RedisAlertStateKey = "go_sensor_alert_state"
+
+const (
+	AlertInactive = "inactive"
+	AlertPending  = "pending"
+	AlertFiring   = "firing"
+)
+
+var alertComparators = map[string]func(a, b float64) bool{
+	">":  func(a, b float64) bool { return a > b },
+	">=": func(a, b float64) bool { return a >= b },
+	"<":  func(a, b float64) bool { return a < b },
+	"<=": func(a, b float64) bool { return a <= b },
+	"==": func(a, b float64) bool { return a == b },
+	"!=": func(a, b float64) bool { return a != b },
+}
+
+//AlertState is persisted in redis so a restart does not fire again
+type AlertState struct {
+	Rule       string  `json:"rule"`
+	State      string  `json:"state"`
+	Since      float64 `json:"since"` //add_time the current state was entered
+	Value      float64 `json:"value"`
+	UpdatedAt  float64 `json:"updated_at"`
+	NotifiedAt float64 `json:"notified_at"`
+}
+
+//AlertTransition is a change of the state of an alert, the ones from or to
+//firing are passed to the notifiers
+type AlertTransition struct {
+	Id    string    `json:"id"`
+	Rule  AlertRule `json:"rule"`
+	From  string    `json:"from"`
+	To    string    `json:"to"`
+	Value float64   `json:"value"`
+	Time  float64   `json:"time"`
+}
+
+var alertStates = struct {
+	sync.Mutex
+	once   sync.Once
+	states map[string]*AlertState
+}{states: map[string]*AlertState{}}
+
+func loadAlertStates() {
+	all, err := Redis().HGetAll(RedisAlertStateKey).Result()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	for rule, str := range all {
+		state := &AlertState{}
+		if json.Unmarshal([]byte(str), state) == nil {
+			alertStates.states[rule] = state
+		}
+	}
+}
+
+//evaluateAlerts runs the rules of a series against a freshly stored reading
+func evaluateAlerts(name string, data map[string]interface{}) {
+	for _, rule := range Config().Alerts {
+		if rule.Series != name && rule.OtherSeries != name {
+			continue
+		}
+		value, ok := alertValue(rule, name, data)
+		if !ok {
+			continue
+		}
+		evaluateAlertRule(rule, value, uploadAddTime(data))
+	}
+}
+
+func evaluateAlertRule(rule AlertRule, value float64, addTime float64) {
+	alertStates.once.Do(loadAlertStates)
+	alertStates.Lock()
+	defer alertStates.Unlock()
+
+	state, ok := alertStates.states[rule.Name]
+	if !ok {
+		state = &AlertState{Rule: rule.Name, State: AlertInactive, Since: addTime}
+		alertStates.states[rule.Name] = state
+	}
+	//backfilled readings must not flip the state back and forth
+	if addTime < state.UpdatedAt {
+		return
+	}
+
+	compare := alertComparators[rule.Comparator]
+	clearThreshold := rule.Threshold
+	if rule.ClearThreshold != nil {
+		clearThreshold = *rule.ClearThreshold
+	}
+
+	from := state.State
+	switch state.State {
+	case AlertFiring:
+		if !compare(value, clearThreshold) {
+			state.State = AlertInactive
+		}
+	case AlertPending:
+		if !compare(value, rule.Threshold) {
+			state.State = AlertInactive
+		} else if addTime-state.Since >= float64(rule.For) {
+			state.State = AlertFiring
+		}
+	default:
+		if compare(value, rule.Threshold) {
+			state.State = AlertPending
+			if rule.For <= 0 {
+				state.State = AlertFiring
+			}
+		}
+	}
+
+	state.Value = value
+	state.UpdatedAt = addTime
+
+	//firing alerts are notified again every Repeat seconds until acknowledged
+	repeat := from == AlertFiring && state.State == AlertFiring && rule.Repeat > 0 &&
+		addTime-state.NotifiedAt >= float64(rule.Repeat)
+	if from == state.State && !repeat {
+		return
+	}
+	if from != state.State && (from != AlertPending || state.State != AlertFiring) {
+		state.Since = addTime
+	}
+	if state.State == AlertFiring || from == AlertFiring {
+		state.NotifiedAt = addTime
+	}
+
+	if byteStr, err := json.Marshal(state); err == nil {
+		Redis().HSet(RedisAlertStateKey, rule.Name, string(byteStr))
+	}
+
+	transition := AlertTransition{Id: newId(), Rule: rule, From: from, To: state.State, Value: value, Time: addTime}
+	recordAlertHistory(transition)
+	if state.State == AlertFiring || from == AlertFiring {
+		notifyAlert(transition)
+	}
+}
+
+func alertState(rule string) (AlertState, bool) {
+	alertStates.once.Do(loadAlertStates)
+	alertStates.Lock()
+	defer alertStates.Unlock()
+	state, ok := alertStates.states[rule]
+	if !ok {
+		return AlertState{}, false
+	}
+	return *state, true
+}
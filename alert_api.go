@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+)
+
+const RedisAlertHistoryKey = "go_sensor_alert_history"
+const AlertHistorySize = 5000
+
+//AlertHistoryEntry is a transition together with its notification results
+type AlertHistoryEntry struct {
+	AlertTransition
+	Deliveries []AlertDelivery `json:"deliveries"`
+}
+
+//CurrentAlert is a pending or firing alert
+type CurrentAlert struct {
+	AlertState
+	Rule AlertRule `json:"rule"`
+	Ack  *AlertAck `json:"ack,omitempty"`
+}
+
+func recordAlertHistory(transition AlertTransition) {
+	byteStr, err := json.Marshal(transition)
+	if err != nil {
+		return
+	}
+	Redis().RPush(RedisAlertHistoryKey, string(byteStr))
+	Redis().LTrim(RedisAlertHistoryKey, -AlertHistorySize, -1)
+}
+
+//alertRules returns the configured rules and the ones behind the stale checks
+func alertRules() map[string]AlertRule {
+	rules := make(map[string]AlertRule)
+	for _, rule := range Config().Alerts {
+		rules[rule.Name] = rule
+	}
+	for series, conf := range Config().Stale {
+		rule := staleRule(series, conf)
+		rules[rule.Name] = rule
+	}
+	return rules
+}
+
+//alertsHandler returns the pending and firing alerts, firing ones first
+func alertsHandler(w http.ResponseWriter, r *http.Request) {
+	alerts := make([]CurrentAlert, 0)
+	for name, rule := range alertRules() {
+		state, ok := alertState(name)
+		if !ok || state.State == AlertInactive {
+			continue
+		}
+		alert := CurrentAlert{AlertState: state, Rule: rule}
+		if ack, ok := alertAck(name); ok {
+			alert.Ack = &ack
+		}
+		alerts = append(alerts, alert)
+	}
+	sort.Slice(alerts, func(i, j int) bool {
+		if alerts[i].State != alerts[j].State {
+			return alerts[i].State == AlertFiring
+		}
+		return alerts[i].Since < alerts[j].Since
+	})
+	writeJson(w, 200, alerts)
+}
+
+//alertHistoryHandler returns the transitions, newest first, optionally of
+//?rule= only and at most ?limit= (default 100)
+func alertHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	limit := 100
+	if l, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && l > 0 {
+		limit = l
+	}
+	rule := r.URL.Query().Get("rule")
+
+	list, _ := Redis().LRange(RedisAlertHistoryKey, 0, -1).Result()
+	deliveries := make(map[string][]AlertDelivery)
+	history := make([]AlertHistoryEntry, 0)
+	for i := len(list) - 1; i >= 0 && len(history) < limit; i-- {
+		var entry AlertHistoryEntry
+		if json.Unmarshal([]byte(list[i]), &entry.AlertTransition) != nil {
+			continue
+		}
+		if rule != "" && entry.Rule.Name != rule {
+			continue
+		}
+
+		if _, ok := deliveries[entry.Rule.Name]; !ok {
+			deliveries[entry.Rule.Name] = alertDeliveries(entry.Rule.Name)
+		}
+		entry.Deliveries = make([]AlertDelivery, 0)
+		for _, delivery := range deliveries[entry.Rule.Name] {
+			if delivery.Transition == entry.Id {
+				entry.Deliveries = append(entry.Deliveries, delivery)
+			}
+		}
+		history = append(history, entry)
+	}
+	writeJson(w, 200, history)
+}
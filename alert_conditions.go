@@ -0,0 +1,106 @@
+package main
+
+import (
+	"math"
+)
+
+const (
+	ConditionValue  = "value"  //the field itself
+	ConditionRate   = "rate"   //change since the oldest reading within the window
+	ConditionZScore = "zscore" //deviation from the mean of the window in standard deviations
+	ConditionDelta  = "delta"  //field minus other_field of other_series
+)
+
+var alertConditions = map[string]bool{
+	"":              true,
+	ConditionValue:  true,
+	ConditionRate:   true,
+	ConditionZScore: true,
+	ConditionDelta:  true,
+}
+
+//latestField returns the field of the latest reading within [from, to]
+func latestField(series string, field string, from float64, to float64) (float64, bool) {
+	readings := readingsSince(RedisDataKeyPrefix+series, from)
+	for i := len(readings) - 1; i >= 0; i-- {
+		if uploadAddTime(readings[i]) > to {
+			continue
+		}
+		if value, ok := readingValue(readings[i], field); ok {
+			return value, true
+		}
+	}
+	return 0, false
+}
+
+//alertValue computes the value a rule compares for a stored reading of
+//series name. ok is false if the rule does not apply or lacks history.
+func alertValue(rule AlertRule, name string, data map[string]interface{}) (float64, bool) {
+	addTime := uploadAddTime(data)
+	window := float64(rule.Window)
+
+	if rule.Condition == ConditionDelta {
+		otherWindow := window
+		if otherWindow <= 0 {
+			otherWindow = 2 * PointInterval
+		}
+		switch name {
+		case rule.Series:
+			value, ok := readingValue(data, rule.Field)
+			other, otherOk := latestField(rule.OtherSeries, rule.OtherField, addTime-otherWindow, addTime)
+			return value - other, ok && otherOk
+		case rule.OtherSeries:
+			other, otherOk := readingValue(data, rule.OtherField)
+			value, ok := latestField(rule.Series, rule.Field, addTime-otherWindow, addTime)
+			return value - other, ok && otherOk
+		}
+		return 0, false
+	}
+
+	if name != rule.Series {
+		return 0, false
+	}
+	value, ok := readingValue(data, rule.Field)
+	if !ok {
+		return 0, false
+	}
+
+	switch rule.Condition {
+	case ConditionRate:
+		for _, reading := range readingsSince(RedisDataKeyPrefix+rule.Series, addTime-window) {
+			if uploadAddTime(reading) >= addTime {
+				break
+			}
+			if first, ok := readingValue(reading, rule.Field); ok {
+				return value - first, true
+			}
+		}
+		return 0, false
+	case ConditionZScore:
+		var values []float64
+		for _, reading := range readingsSince(RedisDataKeyPrefix+rule.Series, addTime-window) {
+			if v, ok := readingValue(reading, rule.Field); ok && uploadAddTime(reading) < addTime {
+				values = append(values, v)
+			}
+		}
+		if len(values) < 2 {
+			return 0, false
+		}
+		mean := 0.0
+		for _, v := range values {
+			mean += v
+		}
+		mean /= float64(len(values))
+		variance := 0.0
+		for _, v := range values {
+			variance += (v - mean) * (v - mean)
+		}
+		stddev := math.Sqrt(variance / float64(len(values)))
+		if stddev == 0 {
+			return 0, false
+		}
+		return (value - mean) / stddev, true
+	}
+
+	return value, true
+}
@@ -0,0 +1,58 @@
+package main
+
+import "testing"
+
+func TestEvaluateAlertRule(t *testing.T) {
+	clear := 28.0
+	rule := AlertRule{Name: "test_hot", Series: "room", Field: "temp", Comparator: ">", Threshold: 30, ClearThreshold: &clear, For: 600}
+
+	steps := []struct {
+		value   float64
+		addTime float64
+		state   string
+		since   float64
+	}{
+		{25, 1000, AlertInactive, 1000},
+		{31, 1600, AlertPending, 1600},
+		{32, 1900, AlertPending, 1600},
+		{33, 2200, AlertFiring, 1600},
+		//backfilled readings are ignored
+		{20, 2000, AlertFiring, 1600},
+		//hysteresis, still above the clear threshold
+		{29, 2800, AlertFiring, 1600},
+		{27, 3400, AlertInactive, 3400},
+		//pending resolves without firing
+		{35, 4000, AlertPending, 4000},
+		{25, 4300, AlertInactive, 4300},
+	}
+	for i, step := range steps {
+		evaluateAlertRule(rule, step.value, step.addTime)
+		state, ok := alertState(rule.Name)
+		if !ok {
+			t.Fatalf("step %d: no state", i)
+		}
+		if state.State != step.state || state.Since != step.since {
+			t.Errorf("step %d: %v at %v = %s since %v, want %s since %v",
+				i, step.value, step.addTime, state.State, state.Since, step.state, step.since)
+		}
+	}
+}
+
+func TestEvaluateAlertRuleImmediate(t *testing.T) {
+	rule := AlertRule{Name: "test_low", Comparator: "<=", Threshold: 10, Repeat: 600}
+
+	evaluateAlertRule(rule, 5, 1000)
+	state, _ := alertState(rule.Name)
+	if state.State != AlertFiring || state.NotifiedAt != 1000 {
+		t.Fatalf("without for = %+v, want firing notified at 1000", state)
+	}
+
+	evaluateAlertRule(rule, 4, 1300)
+	if state, _ = alertState(rule.Name); state.NotifiedAt != 1000 {
+		t.Errorf("notified again after 300s: %+v", state)
+	}
+	evaluateAlertRule(rule, 4, 1600)
+	if state, _ = alertState(rule.Name); state.NotifiedAt != 1600 || state.Since != 1000 {
+		t.Errorf("repeat = %+v, want notified at 1600 and firing since 1000", state)
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+//RawField keeps the uncorrected values of the calibrated fields of a reading
+const RawField = "raw"
+
+//correct applies a calibration to a raw value: the polynomial if given,
+//else the line through the two points, else gain and offset
+func (c Calibration) correct(value float64) float64 {
+	if len(c.Polynomial) > 0 {
+		result := 0.0
+		for i := len(c.Polynomial) - 1; i >= 0; i-- {
+			result = result*value + c.Polynomial[i]
+		}
+		return result
+	}
+	if len(c.Points) == 2 {
+		p, q := c.Points[0], c.Points[1]
+		return p[1] + (value-p[0])*(q[1]-p[1])/(q[0]-p[0])
+	}
+	gain := 1.0
+	if c.Gain != nil {
+		gain = *c.Gain
+	}
+	return value*gain + c.Offset
+}
+
+func (c Calibration) validate() error {
+	if len(c.Polynomial) > 0 && len(c.Points) > 0 {
+		return errors.New("either polynomial or points")
+	}
+	if len(c.Points) > 0 && (len(c.Points) != 2 || c.Points[0][0] == c.Points[1][0]) {
+		return errors.New("points needs two points of different raw values")
+	}
+	return nil
+}
+
+//applyCalibration corrects the calibrated fields of a reading in place, the
+//raw values are kept under RawField
+func applyCalibration(name string, data map[string]interface{}) {
+	calibrations := Config().Calibration[name]
+	if len(calibrations) == 0 {
+		return
+	}
+
+	raw, ok := data[RawField].(map[string]interface{})
+	if !ok {
+		raw = make(map[string]interface{})
+	}
+	for field, calibration := range calibrations {
+		value, ok := data[field].(float64)
+		if !ok {
+			continue
+		}
+		raw[field] = value
+		data[field] = calibration.correct(value)
+	}
+	if len(raw) > 0 {
+		data[RawField] = raw
+	}
+}
+
+//recalibrate restores the raw values of a stored reading and applies the
+//current calibration and derived fields again
+func recalibrate(name string, data map[string]interface{}) {
+	if raw, ok := data[RawField].(map[string]interface{}); ok {
+		for field, value := range raw {
+			data[field] = value
+		}
+		delete(data, RawField)
+	}
+	applyCalibration(name, data)
+	applyDerived(name, data)
+}
+
+//recalibrateSeries rewrites the stored history of a series with the current
+//calibration. It returns the number of readings rewritten.
+func recalibrateSeries(name string) (int, error) {
+	key := seriesKey(RedisDataKeyPrefix + name)
+	count := 0
+	//new readings are added and old ones trimmed meanwhile, so retry on change
+	for attempt := 0; attempt < 3; attempt++ {
+		err := Redis().Watch(func(tx *redis.Tx) error {
+			list, err := tx.ZRange(key, 0, -1).Result()
+			if err != nil {
+				return err
+			}
+			_, err = tx.TxPipelined(func(pipe redis.Pipeliner) error {
+				count = 0
+				for _, jsonStr := range list {
+					data := make(map[string]interface{})
+					if json.Unmarshal([]byte(jsonStr), &data) != nil {
+						continue
+					}
+					recalibrate(name, data)
+					byteStr, err := json.Marshal(data)
+					if err != nil || string(byteStr) == jsonStr {
+						continue
+					}
+					pipe.ZRem(key, jsonStr)
+					pipe.ZAdd(key, redis.Z{Score: uploadAddTime(data), Member: string(byteStr)})
+					count++
+				}
+				return nil
+			})
+			return err
+		}, key)
+		if err != redis.TxFailedErr {
+			return count, err
+		}
+	}
+	return 0, errors.New("series changed while recalibrating, try again")
+}
+
+//recalibrateHandler: POST ?series= recomputes the history of a series from
+//the raw values with the current calibration
+func recalibrateHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		writeJsonError(w, 405, errors.New("method not allowed"))
+		return
+	}
+	series := r.URL.Query().Get("series")
+	if series == "" {
+		writeJsonError(w, 400, errors.New("series is required"))
+		return
+	}
+
+	start := time.Now()
+	count, err := recalibrateSeries(series)
+	if err != nil {
+		writeJsonError(w, 500, err)
+		return
+	}
+	sensorJsonCache()
+	writeJson(w, 200, map[string]interface{}{"series": series, "recalibrated": count})
+	fmt.Println(time.Since(start), r.URL)
+}
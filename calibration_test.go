@@ -0,0 +1,78 @@
+package main
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestCalibrationCorrect(t *testing.T) {
+	gain := 0.5
+	tests := []struct {
+		name        string
+		calibration Calibration
+		value       float64
+		want        float64
+	}{
+		{"none", Calibration{}, 21.5, 21.5},
+		{"offset", Calibration{Offset: -1.5}, 21.5, 20},
+		{"gain and offset", Calibration{Gain: &gain, Offset: 1}, 40, 21},
+		{"two points", Calibration{Points: [][2]float64{{0, 1}, {100, 99}}}, 50, 50},
+		{"two points outside", Calibration{Points: [][2]float64{{10, 12}, {20, 22}}}, 30, 32},
+		{"polynomial", Calibration{Polynomial: []float64{1, 2, 3}}, 2, 17},
+		{"polynomial over gain", Calibration{Polynomial: []float64{0, 2}, Gain: &gain, Offset: 5}, 3, 6},
+	}
+	for _, test := range tests {
+		if got := test.calibration.correct(test.value); math.Abs(got-test.want) > 1e-9 {
+			t.Errorf("%s: correct(%v) = %v, want %v", test.name, test.value, got, test.want)
+		}
+	}
+}
+
+func TestCalibrationValidate(t *testing.T) {
+	tests := []struct {
+		calibration Calibration
+		valid       bool
+	}{
+		{Calibration{Offset: 1}, true},
+		{Calibration{Points: [][2]float64{{0, 1}, {100, 99}}}, true},
+		{Calibration{Polynomial: []float64{1, 2}}, true},
+		{Calibration{Polynomial: []float64{1}, Points: [][2]float64{{0, 1}, {100, 99}}}, false},
+		{Calibration{Points: [][2]float64{{0, 1}}}, false},
+		{Calibration{Points: [][2]float64{{0, 1}, {10, 11}, {20, 21}}}, false},
+		{Calibration{Points: [][2]float64{{5, 1}, {5, 2}}}, false},
+	}
+	for _, test := range tests {
+		if err := test.calibration.validate(); (err == nil) != test.valid {
+			t.Errorf("%+v.validate() = %v, want valid %v", test.calibration, err, test.valid)
+		}
+	}
+}
+
+func TestApplyCalibration(t *testing.T) {
+	conf := Config()
+	saved := conf.Calibration
+	defer func() { conf.Calibration = saved }()
+	conf.Calibration = map[string]map[string]Calibration{"room": {"temp": {Offset: -1}}}
+
+	data := map[string]interface{}{"temp": 21.5, "humidity": 40.0}
+	applyCalibration("room", data)
+	want := map[string]interface{}{"temp": 20.5, "humidity": 40.0, RawField: map[string]interface{}{"temp": 21.5}}
+	if !reflect.DeepEqual(data, want) {
+		t.Errorf("applyCalibration = %v, want %v", data, want)
+	}
+
+	//recomputed from the raw value, not calibrated twice
+	conf.Calibration["room"]["temp"] = Calibration{Offset: 2}
+	recalibrate("room", data)
+	want = map[string]interface{}{"temp": 23.5, "humidity": 40.0, RawField: map[string]interface{}{"temp": 21.5}}
+	if !reflect.DeepEqual(data, want) {
+		t.Errorf("recalibrate = %v, want %v", data, want)
+	}
+
+	other := map[string]interface{}{"temp": 21.5}
+	applyCalibration("other", other)
+	if _, ok := other[RawField]; ok || other["temp"] != 21.5 {
+		t.Errorf("uncalibrated series changed: %v", other)
+	}
+}
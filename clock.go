@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+const RedisDeviceKey = "go_sensor_devices"
+
+const (
+	SkewCorrect = "correct"
+	SkewReject  = "reject"
+)
+
+//DeviceStatus is kept per chip for the device status API
+type DeviceStatus struct {
+	Chip        string  `json:"chip"`
+	LastSeen    int64   `json:"last_seen"` //receive time of the latest upload
+	LastAddTime float64 `json:"last_add_time"`
+	Skew        float64 `json:"skew"` //seconds the device clock is ahead, negative if behind
+	SkewedAt    int64   `json:"skewed_at"`
+	InSync      bool    `json:"in_sync"`
+	Corrected   int64   `json:"corrected"`
+	Rejected    int64   `json:"rejected"`
+}
+
+var deviceLock sync.Mutex
+
+func deviceStatus(chip string) DeviceStatus {
+	status := DeviceStatus{Chip: chip, InSync: true}
+	if str, err := Redis().HGet(RedisDeviceKey, chip).Result(); err == nil {
+		json.Unmarshal([]byte(str), &status)
+	}
+	return status
+}
+
+//updateDevice applies fn to the stored status of a chip
+func updateDevice(chip string, fn func(status *DeviceStatus)) {
+	deviceLock.Lock()
+	defer deviceLock.Unlock()
+	status := deviceStatus(chip)
+	fn(&status)
+	if byteStr, err := json.Marshal(status); err == nil {
+		Redis().HSet(RedisDeviceKey, chip, string(byteStr))
+	}
+}
+
+//checkClockSkew compares the device time an upload was sent at with the
+//receive time. Readings outside the skew window are rejected or moved by the
+//skew, the original add_time is kept as device_time.
+func checkClockSkew(chip string, data map[string]interface{}, sentAt float64) error {
+	addTime := uploadAddTime(data)
+	now := time.Now().Unix()
+	skew := sentAt - float64(now)
+	conf := Config().ClockSkew
+	inSync := skew <= float64(conf.MaxAhead) && skew >= -float64(conf.MaxBehind)
+
+	metricSet("gosensor_clock_skew_seconds", skew, "chip", chip)
+	updateDevice(chip, func(status *DeviceStatus) {
+		status.LastSeen = now
+		status.LastAddTime = math.Max(status.LastAddTime, addTime)
+		status.Skew = skew
+		status.SkewedAt = now
+		status.InSync = inSync
+		if inSync {
+			return
+		}
+		if conf.Action == SkewReject {
+			status.Rejected++
+		} else {
+			status.Corrected++
+		}
+	})
+	if inSync {
+		return nil
+	}
+
+	action := conf.Action
+	if action == "" {
+		action = SkewCorrect
+	}
+	metricAdd("gosensor_clock_skew_readings_total", 1, "chip", chip, "action", action)
+	if action == SkewReject {
+		return &uploadError{
+			Status:  422,
+			Message: "clock skew",
+			Chip:    chip,
+			Fields:  []fieldError{{Field: "add_time", Error: fmt.Sprintf("clock skew of %.0fs", skew), Value: addTime}},
+		}
+	}
+	data["device_time"] = addTime
+	data["add_time"] = addTime - skew
+	return nil
+}
+
+//touchDevice records an upload the clock skew is not measured for
+func touchDevice(chip string, addTime float64) {
+	updateDevice(chip, func(status *DeviceStatus) {
+		status.LastSeen = time.Now().Unix()
+		status.LastAddTime = math.Max(status.LastAddTime, addTime)
+	})
+}
+
+//uploadSentAt returns the device time an upload was sent at, from the
+//sent_at field of the reading or the Date header, 0 if unknown
+func uploadSentAt(r *http.Request, data map[string]interface{}) float64 {
+	if sentAt, ok := data["sent_at"].(float64); ok {
+		delete(data, "sent_at")
+		return sentAt
+	}
+	if date, err := http.ParseTime(r.Header.Get("Date")); err == nil {
+		return float64(date.Unix())
+	}
+	return 0
+}
+
+//devicesHandler lists the status of every device that uploaded, or of
+//?chip= only
+func devicesHandler(w http.ResponseWriter, r *http.Request) {
+	if chip := r.URL.Query().Get("chip"); chip != "" {
+		if ok, _ := Redis().HExists(RedisDeviceKey, chip).Result(); !ok {
+			writeJsonError(w, 404, fmt.Errorf("unknown chip %s", chip))
+			return
+		}
+		writeJson(w, 200, deviceStatus(chip))
+		return
+	}
+
+	all, err := Redis().HGetAll(RedisDeviceKey).Result()
+	if err != nil {
+		writeJsonError(w, 500, err)
+		return
+	}
+	devices := make([]DeviceStatus, 0, len(all))
+	for _, str := range all {
+		var status DeviceStatus
+		if json.Unmarshal([]byte(str), &status) == nil {
+			devices = append(devices, status)
+		}
+	}
+	sort.Slice(devices, func(i, j int) bool {
+		return devices[i].Chip < devices[j].Chip
+	})
+	writeJson(w, 200, devices)
+}
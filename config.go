@@ -0,0 +1,397 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"text/template"
+)
+
+const ConfigFile = "config.json"
+
+//FieldSchema describes one field of an uploaded reading.
+type FieldSchema struct {
+	Type     string   `json:"type"` //number, string or bool
+	Required bool     `json:"required"`
+	Unit     string   `json:"unit"`
+	Min      *float64 `json:"min"`
+	Max      *float64 `json:"max"`
+}
+
+//InfluxWriteConfig configures the InfluxDB compatible /write endpoint
+type InfluxWriteConfig struct {
+	//series name of a point, {measurement} and {<tag>} are replaced
+	SeriesTemplate string `json:"series_template"`
+}
+
+//MqttSubscription maps the messages of a topic filter to readings.
+//Chip and Field may use {topic}, {last} and {1}..{n} for the wildcard levels.
+type MqttSubscription struct {
+	Topic string `json:"topic"`
+	Chip  string `json:"chip"`  //defaults to {last}
+	Field string `json:"field"` //empty for JSON payloads, defaults to {last} for plain ones
+}
+
+//MqttPublish republishes every stored reading, {name} and {field} are
+//replaced in Topic
+type MqttPublish struct {
+	Enabled           bool   `json:"enabled"`
+	Topic             string `json:"topic"`
+	Retain            bool   `json:"retain"`
+	AvailabilityTopic string `json:"availability_topic"` //online/offline, the latter as LWT
+}
+
+//MqttDiscovery announces the series to Home Assistant, requires Publish
+type MqttDiscovery struct {
+	Enabled bool   `json:"enabled"`
+	Prefix  string `json:"prefix"`
+}
+
+type MqttConfig struct {
+	Broker        string             `json:"broker"` //e.g. tcp://127.0.0.1:1883, empty to disable
+	ClientId      string             `json:"client_id"`
+	Username      string             `json:"username"`
+	Password      string             `json:"password"`
+	Subscriptions []MqttSubscription `json:"subscriptions"`
+	Publish       MqttPublish        `json:"publish"`
+	Discovery     MqttDiscovery      `json:"discovery"`
+}
+
+//GraphiteConfig forwards readings to a Carbon plaintext endpoint,
+//{sensor} and {field} are replaced in Template
+type GraphiteConfig struct {
+	Address  string `json:"address"` //host:2003, empty to disable
+	Template string `json:"template"`
+}
+
+//InfluxSinkConfig mirrors readings into InfluxDB, Version 1 uses
+//Database/RetentionPolicy, Version 2 Org/Bucket/Token
+type InfluxSinkConfig struct {
+	URL             string `json:"url"` //e.g. http://127.0.0.1:8086, empty to disable
+	Version         int    `json:"version"`
+	Database        string `json:"database"`
+	RetentionPolicy string `json:"retention_policy"`
+	Username        string `json:"username"`
+	Password        string `json:"password"`
+	Org             string `json:"org"`
+	Bucket          string `json:"bucket"`
+	Token           string `json:"token"`
+	BatchSize       int    `json:"batch_size"`
+	FlushInterval   int    `json:"flush_interval"` //seconds
+	Retries         int    `json:"retries"`
+	SpoolFile       string `json:"spool_file"`
+	SpoolMaxBytes   int64  `json:"spool_max_bytes"`
+}
+
+//SinkConfig is the queue and retry policy of a sink, URL and Path are
+//used by the webhook and file sinks
+type SinkConfig struct {
+	QueueSize    int    `json:"queue_size"`
+	Retries      int    `json:"retries"`       //-1 retries forever
+	RetryBackoff int    `json:"retry_backoff"` //seconds, doubled on every retry
+	URL          string `json:"url"`
+	Path         string `json:"path"`
+}
+
+//AlertRule fires when the Condition of Field of Series compares true
+//against Threshold for at least For seconds and resolves once it no longer
+//does against ClearThreshold (defaults to Threshold)
+type AlertRule struct {
+	Name           string   `json:"name"`
+	Series         string   `json:"series"`
+	Field          string   `json:"field"`
+	Condition      string   `json:"condition"` //value (default), rate, zscore or delta
+	Window         int      `json:"window"`    //seconds of history for rate and zscore
+	OtherSeries    string   `json:"other_series"`
+	OtherField     string   `json:"other_field"`
+	Comparator     string   `json:"comparator"` //>, >=, <, <=, ==, !=
+	Threshold      float64  `json:"threshold"`
+	ClearThreshold *float64 `json:"clear_threshold"`
+	For            int      `json:"for"`
+	Repeat         int      `json:"repeat"` //seconds between notifications while firing, 0 for once
+	Severity       string   `json:"severity"`
+}
+
+//StaleConfig marks a series stale when its latest reading (by its own
+//add_time) is older than MaxAge seconds
+type StaleConfig struct {
+	MaxAge   int    `json:"max_age"`
+	Severity string `json:"severity"`
+}
+
+//WebhookConfig delivers alert transitions as POSTs, Template is a Go
+//template of the body with the funcs json and time
+type WebhookConfig struct {
+	Name        string            `json:"name"`
+	URL         string            `json:"url"`
+	Template    string            `json:"template"`
+	ContentType string            `json:"content_type"`
+	Headers     map[string]string `json:"headers"`
+	Secret      string            `json:"secret"` //HMAC-SHA256 key of X-GoSensor-Signature
+	Retries     int               `json:"retries"`
+}
+
+//EmailConfig mails alert transitions, Subject and Body are Go templates of
+//EmailData. With Digest (seconds) the transitions are batched per window.
+type EmailConfig struct {
+	Host     string   `json:"host"` //empty to disable
+	Port     int      `json:"port"`
+	StartTLS string   `json:"starttls"` //auto, always or never
+	Username string   `json:"username"`
+	Password string   `json:"password"`
+	From     string   `json:"from"`
+	To       []string `json:"to"`
+	Subject  string   `json:"subject"`
+	Body     string   `json:"body"`
+	Digest   int      `json:"digest"`
+	Retries  int      `json:"retries"`
+}
+
+//DerivedSeries stores Field computed by Expr over the fields of the Source
+//series' readings, e.g. "dew_point(temperature, humidity)", and charts it
+//as Name
+type DerivedSeries struct {
+	Name   string `json:"name"`
+	Source string `json:"source"`
+	Field  string `json:"field"`
+	Expr   string `json:"expr"`
+	Unit   string `json:"unit"`
+	Color  string `json:"color"`
+	Order  int    `json:"order"`
+}
+
+//VirtualSeries is computed at query time by Expr over series.field
+//variables aligned on the PointInterval grid, e.g.
+//"temperature_two.temperature - temperature_three.temperature"
+type VirtualSeries struct {
+	Name  string `json:"name"`
+	Expr  string `json:"expr"`
+	Unit  string `json:"unit"`
+	Color string `json:"color"`
+	Order int    `json:"order"`
+}
+
+//Calibration corrects a field on ingest. Polynomial holds the
+//coefficients c0 + c1*x + c2*x^2 ..., Points two [raw, actual] reference
+//readings, otherwise value*Gain + Offset is used.
+type Calibration struct {
+	Offset     float64      `json:"offset"`
+	Gain       *float64     `json:"gain"` //defaults to 1
+	Points     [][2]float64 `json:"points"`
+	Polynomial []float64    `json:"polynomial"`
+}
+
+//OutlierFilter rejects or flags (Action) readings of a field outside
+//Min/Max, changing by more than MaxDelta per PointInterval since the last
+//stored value, or deviating from the median of the last Window values by
+//more than MaxDeviation or Sigmas times the scaled MAD (Hampel)
+type OutlierFilter struct {
+	Min          *float64 `json:"min"`
+	Max          *float64 `json:"max"`
+	MaxDelta     float64  `json:"max_delta"`
+	Window       int      `json:"window"`
+	MaxDeviation float64  `json:"max_deviation"`
+	Sigmas       float64  `json:"sigmas"`
+	Action       string   `json:"action"` //reject (default) or flag
+}
+
+//ClockSkewConfig is the window device clocks may be off the receive time,
+//readings outside it are moved by the skew (correct) or rejected
+type ClockSkewConfig struct {
+	MaxAhead  int    `json:"max_ahead"`  //seconds
+	MaxBehind int    `json:"max_behind"` //seconds
+	Action    string `json:"action"`     //correct (default) or reject
+}
+
+//DedupeConfig remembers stored readings for TTL seconds so repeated
+//submissions are not stored twice, 0 disables it
+type DedupeConfig struct {
+	TTL int `json:"ttl"`
+}
+
+type Configuration struct {
+	//chip => field => schema, chips without a schema are accepted as is
+	Schemas     map[string]map[string]FieldSchema `json:"schemas"`
+	InfluxWrite InfluxWriteConfig                 `json:"influx_write"`
+	Mqtt        MqttConfig                        `json:"mqtt"`
+	Graphite    GraphiteConfig                    `json:"graphite"`
+	InfluxSink  InfluxSinkConfig                  `json:"influx_sink"`
+	Sinks       map[string]SinkConfig             `json:"sinks"`
+	Alerts      []AlertRule                       `json:"alerts"`
+	Stale       map[string]StaleConfig            `json:"stale"`
+	Webhooks    []WebhookConfig                   `json:"webhooks"`
+	Email       EmailConfig                       `json:"email"`
+	Derived     []DerivedSeries                   `json:"derived"`
+	Virtual     []VirtualSeries                   `json:"virtual"`
+	//series => field => calibration
+	Calibration map[string]map[string]Calibration `json:"calibration"`
+	//series => field => filter
+	Filters   map[string]map[string]OutlierFilter `json:"filters"`
+	ClockSkew ClockSkewConfig                     `json:"clock_skew"`
+	Dedupe    DedupeConfig                        `json:"dedupe"`
+}
+
+func (c *Configuration) validate() error {
+	names := make(map[string]bool)
+	for _, rule := range c.Alerts {
+		if rule.Name == "" || names[rule.Name] {
+			return fmt.Errorf("alert rule name %q is empty or not unique", rule.Name)
+		}
+		names[rule.Name] = true
+		if _, ok := alertComparators[rule.Comparator]; !ok {
+			return fmt.Errorf("alert rule %s: unknown comparator %q", rule.Name, rule.Comparator)
+		}
+		if !alertConditions[rule.Condition] {
+			return fmt.Errorf("alert rule %s: unknown condition %q", rule.Name, rule.Condition)
+		}
+		if (rule.Condition == ConditionRate || rule.Condition == ConditionZScore) && rule.Window <= 0 {
+			return fmt.Errorf("alert rule %s: %s needs a window", rule.Name, rule.Condition)
+		}
+		if rule.Condition == ConditionDelta && (rule.OtherSeries == "" || rule.OtherField == "") {
+			return fmt.Errorf("alert rule %s: delta needs other_series and other_field", rule.Name)
+		}
+	}
+	for _, derived := range c.Derived {
+		if derived.Name == "" || derived.Source == "" || derived.Field == "" {
+			return fmt.Errorf("derived series %q: name, source and field are required", derived.Name)
+		}
+		if _, err := parseExpr(derived.Expr); err != nil {
+			return fmt.Errorf("derived series %s: %s", derived.Name, err)
+		}
+	}
+	for _, virtual := range c.Virtual {
+		if virtual.Name == "" {
+			return errors.New("virtual series: name is required")
+		}
+		if err := validateVirtual(virtual); err != nil {
+			return fmt.Errorf("virtual series %s: %s", virtual.Name, err)
+		}
+	}
+	for series, fields := range c.Calibration {
+		for field, calibration := range fields {
+			if err := calibration.validate(); err != nil {
+				return fmt.Errorf("calibration %s.%s: %s", series, field, err)
+			}
+		}
+	}
+	for series, fields := range c.Filters {
+		for field, filter := range fields {
+			if filter.Action != "" && filter.Action != FilterReject && filter.Action != FilterFlag {
+				return fmt.Errorf("filter %s.%s: unknown action %q", series, field, filter.Action)
+			}
+			if (filter.MaxDeviation > 0 || filter.Sigmas > 0) && filter.Window < 3 {
+				return fmt.Errorf("filter %s.%s: median and hampel need a window of at least 3", series, field)
+			}
+		}
+	}
+	if c.ClockSkew.Action != "" && c.ClockSkew.Action != SkewCorrect && c.ClockSkew.Action != SkewReject {
+		return fmt.Errorf("clock_skew: unknown action %q", c.ClockSkew.Action)
+	}
+	if c.Email.Host != "" {
+		if c.Email.From == "" || len(c.Email.To) == 0 {
+			return errors.New("email: from and to are required")
+		}
+		for _, text := range []string{c.Email.Subject, c.Email.Body} {
+			if _, err := template.New("email").Funcs(webhookFuncs).Parse(text); err != nil {
+				return fmt.Errorf("email: %s", err)
+			}
+		}
+	}
+	for _, webhook := range c.Webhooks {
+		if webhook.URL == "" {
+			return fmt.Errorf("webhook %s: url is empty", webhook.Name)
+		}
+		if _, err := template.New(webhook.Name).Funcs(webhookFuncs).Parse(webhook.Template); err != nil {
+			return fmt.Errorf("webhook %s: %s", webhook.Name, err)
+		}
+	}
+	return nil
+}
+
+func (c *Configuration) sinkConfig(name string) SinkConfig {
+	conf := c.Sinks[name]
+	if conf.QueueSize <= 0 {
+		conf.QueueSize = 1000
+	}
+	if conf.RetryBackoff <= 0 {
+		conf.RetryBackoff = 1
+	}
+	return conf
+}
+
+var configOnce sync.Once
+var configInstance *Configuration
+
+func float64Ptr(f float64) *float64 {
+	return &f
+}
+
+func defaultConfig() *Configuration {
+	dht22 := map[string]FieldSchema{
+		"temperature": {Type: "number", Required: true, Unit: "Degrees", Min: float64Ptr(-40), Max: float64Ptr(85)},
+		"humidity":    {Type: "number", Required: true, Unit: "Percent", Min: float64Ptr(0), Max: float64Ptr(100)},
+	}
+	return &Configuration{
+		Schemas: map[string]map[string]FieldSchema{
+			"one":   dht22,
+			"two":   dht22,
+			"three": dht22,
+			"four":  dht22,
+		},
+		InfluxWrite: InfluxWriteConfig{SeriesTemplate: "{measurement}"},
+		Mqtt: MqttConfig{
+			ClientId: "goSensor",
+			Publish: MqttPublish{
+				Topic:             "goSensor/{name}/{field}",
+				Retain:            true,
+				AvailabilityTopic: "goSensor/status",
+			},
+			Discovery: MqttDiscovery{Prefix: "homeassistant"},
+		},
+		Graphite: GraphiteConfig{Template: "home.{sensor}.{field}"},
+		InfluxSink: InfluxSinkConfig{
+			Version:       1,
+			Database:      "goSensor",
+			BatchSize:     500,
+			FlushInterval: 10,
+			Retries:       3,
+			SpoolFile:     "goSensor.influx.spool",
+			SpoolMaxBytes: 64 << 20,
+		},
+		Sinks: map[string]SinkConfig{
+			"mqtt":     {QueueSize: 1000, Retries: 3},
+			"influx":   {QueueSize: 5000},
+			"graphite": {QueueSize: 10000, Retries: -1},
+			"webhook":  {QueueSize: 1000, Retries: 3},
+			"file":     {QueueSize: 1000, Retries: 3},
+		},
+		Email:     EmailConfig{Port: 25, StartTLS: "auto", Retries: 3},
+		ClockSkew: ClockSkewConfig{MaxAhead: 300, MaxBehind: 3600, Action: SkewCorrect},
+		Dedupe:    DedupeConfig{TTL: 2 * DaysRange * 86400},
+	}
+}
+
+//singleton, falls back to the defaults if config.json does not exist
+func Config() *Configuration {
+	configOnce.Do(func() {
+		configInstance = defaultConfig()
+
+		b, err := ioutil.ReadFile(ConfigFile)
+		if os.IsNotExist(err) {
+			return
+		}
+		if err != nil {
+			panic(err)
+		}
+		if err := json.Unmarshal(b, configInstance); err != nil {
+			panic(fmt.Errorf("%s: %s", ConfigFile, err))
+		}
+		if err := configInstance.validate(); err != nil {
+			panic(fmt.Errorf("%s: %s", ConfigFile, err))
+		}
+	})
+	return configInstance
+}
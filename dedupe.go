@@ -0,0 +1,84 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const RedisDedupePrefix = "go_sensor_dedupe_"
+
+//errDuplicate is returned for readings stored before, they are acknowledged
+//to the client as if stored
+var errDuplicate = errors.New("duplicate reading")
+
+//dedupeKey is what a reading is remembered by: its message_id if given,
+//else its series, device time and what was measured (the field names and
+//tags). Clock skew correction moves add_time, retries keep the device time
+//but may differ in volatile values like rssi or uptime. Readings timed by
+//the server (add_time is an int64 then) have no device time to tell a
+//retry by, only an identical reading is a duplicate of them.
+func dedupeKey(name string, data map[string]interface{}) string {
+	if id, ok := data["message_id"].(string); ok && id != "" {
+		return RedisDedupePrefix + "id_" + name + "_" + id
+	}
+	addTime, deviceTime := data["add_time"].(float64)
+	if t, ok := data["device_time"].(float64); ok {
+		addTime, deviceTime = t, true
+	}
+	if !deviceTime {
+		addTime = uploadAddTime(data)
+	}
+
+	var parts []string
+	for field, value := range data {
+		switch field {
+		case "add_time", "device_time", "chip", "name":
+			continue
+		}
+		if field == "tags" || !deviceTime {
+			byteStr, _ := json.Marshal(value)
+			field += "=" + string(byteStr)
+		}
+		parts = append(parts, field)
+	}
+	sort.Strings(parts)
+	sum := sha1.Sum([]byte(strings.Join(parts, "\n")))
+	return RedisDedupePrefix + name + "_" + strconv.FormatFloat(addTime, 'f', -1, 64) + "_" + hex.EncodeToString(sum[:])
+}
+
+func dedupeTTL() time.Duration {
+	return time.Duration(Config().Dedupe.TTL) * time.Second
+}
+
+//seenReading reports whether a reading was stored before without claiming
+//it, so uploads can be answered before anything else is done with them
+func seenReading(name string, data map[string]interface{}) bool {
+	if dedupeTTL() <= 0 {
+		return false
+	}
+	count, err := Redis().Exists(dedupeKey(name, data)).Result()
+	return err == nil && count > 0
+}
+
+//claimReading remembers a reading about to be stored, it returns false if
+//it was stored before
+func claimReading(name string, data map[string]interface{}) bool {
+	if dedupeTTL() <= 0 {
+		return true
+	}
+	isNew, err := Redis().SetNX(dedupeKey(name, data), 1, dedupeTTL()).Result()
+	return err != nil || isNew
+}
+
+//releaseReading forgets a claimed reading that was not stored after all
+func releaseReading(name string, data map[string]interface{}) {
+	if dedupeTTL() > 0 {
+		Redis().Del(dedupeKey(name, data))
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import "testing"
+
+func TestDedupeKey(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b map[string]interface{}
+		same bool
+	}{
+		{
+			"retry with changed rssi",
+			map[string]interface{}{"add_time": 1600000000.0, "temp": 21.5, "rssi": -60.0},
+			map[string]interface{}{"add_time": 1600000000.0, "temp": 21.5, "rssi": -71.0},
+			true,
+		},
+		{
+			"retry after clock skew correction",
+			map[string]interface{}{"add_time": 1600000000.0, "temp": 21.5},
+			map[string]interface{}{"add_time": 1600000600.0, "device_time": 1600000000.0, "temp": 21.5},
+			true,
+		},
+		{
+			"another device time",
+			map[string]interface{}{"add_time": 1600000000.0, "temp": 21.5},
+			map[string]interface{}{"add_time": 1600000001.0, "temp": 21.5},
+			false,
+		},
+		{
+			"two fields in the same second",
+			map[string]interface{}{"add_time": int64(1600000000), "temp": 21.5},
+			map[string]interface{}{"add_time": int64(1600000000), "humidity": 40.0},
+			false,
+		},
+		{
+			"two fields at the same device time",
+			map[string]interface{}{"add_time": 1600000000.0, "temp": 21.5},
+			map[string]interface{}{"add_time": 1600000000.0, "humidity": 40.0},
+			false,
+		},
+		{
+			"two values in the same second",
+			map[string]interface{}{"add_time": int64(1600000000), "temp": 21.5},
+			map[string]interface{}{"add_time": int64(1600000000), "temp": 21.6},
+			false,
+		},
+		{
+			"identical in the same second",
+			map[string]interface{}{"add_time": int64(1600000000), "temp": 21.5},
+			map[string]interface{}{"add_time": int64(1600000000), "temp": 21.5},
+			true,
+		},
+		{
+			"other tags",
+			map[string]interface{}{"add_time": 1600000000.0, "usage": 1.0, "tags": map[string]string{"cpu": "cpu0"}},
+			map[string]interface{}{"add_time": 1600000000.0, "usage": 2.0, "tags": map[string]string{"cpu": "cpu1"}},
+			false,
+		},
+		{
+			"message_id",
+			map[string]interface{}{"add_time": int64(1600000000), "temp": 21.5, "message_id": "m1"},
+			map[string]interface{}{"add_time": int64(1600000009), "temp": 22.0, "message_id": "m1"},
+			true,
+		},
+	}
+	for _, test := range tests {
+		a, b := dedupeKey("kitchen", test.a), dedupeKey("kitchen", test.b)
+		if (a == b) != test.same {
+			t.Errorf("%s: keys %q and %q, want same %v", test.name, a, b, test.same)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+)
+
+var derivedExprs = struct {
+	sync.Mutex
+	exprs map[string]exprNode
+}{exprs: map[string]exprNode{}}
+
+//compiledExpr parses an expression once, the config is validated on load
+func compiledExpr(src string) (exprNode, error) {
+	derivedExprs.Lock()
+	defer derivedExprs.Unlock()
+	if node, ok := derivedExprs.exprs[src]; ok {
+		return node, nil
+	}
+	node, err := parseExpr(src)
+	if err == nil {
+		derivedExprs.exprs[src] = node
+	}
+	return node, err
+}
+
+//applyDerived adds the derived fields of a series to a reading before it is
+//stored, so they are kept and charted like any measured field
+func applyDerived(name string, data map[string]interface{}) {
+	vars := func(field string) (float64, bool) {
+		v, ok := readingValue(data, field)
+		return v, ok
+	}
+
+	for _, derived := range Config().Derived {
+		if derived.Source != name {
+			continue
+		}
+		node, err := compiledExpr(derived.Expr)
+		if err != nil {
+			continue
+		}
+		value, err := node.eval(vars)
+		if err != nil {
+			fmt.Println("derived", derived.Name, err)
+			continue
+		}
+		data[derived.Field] = value
+	}
+}
+
+//derivedSeries returns the chart definitions of the derived series, in the
+//format of sensorSeries()
+func derivedSeries() map[string]map[string]interface{} {
+	series := make(map[string]map[string]interface{})
+	for _, derived := range Config().Derived {
+		color := derived.Color
+		if color == "" {
+			color = "#66cc66"
+		}
+		series[derived.Name] = map[string]interface{}{
+			"name":           derived.Name,
+			"redis_key":      RedisDataKeyPrefix + derived.Source,
+			"point_start":    0,
+			"point_interval": PointInterval,
+			"index":          derived.Field,
+			"color":          color,
+			"order":          derived.Order,
+			"unit":           derived.Unit,
+			derived.Field:    []interface{}{},
+			"max":            -9999.0,
+			"min":            99999.0,
+			"max_time":       0,
+			"min_time":       0,
+		}
+	}
+	return series
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+const DefaultEmailSubject = `[goSensor] {{if eq (len .Transitions) 1}}{{with index .Transitions 0}}{{.Rule.Name}} is {{.Status}}{{end}}` +
+	`{{else}}{{len .Transitions}} alert notifications{{end}}`
+
+const DefaultEmailBody = `{{range .Transitions}}{{time .Time}} {{.Rule.Name}} ({{.Rule.Severity}}) is {{.Status}}: ` +
+	`{{.Rule.Series}} {{.Rule.Field}} = {{.Value}}
+{{end}}`
+
+//EmailData is passed to the subject and body templates
+type EmailData struct {
+	Transitions []AlertTransition
+}
+
+//emailNotifier mails every transition, or with a digest window collects
+//them and sends one mail per window
+type emailNotifier struct {
+	conf EmailConfig
+}
+
+type digestEntry struct {
+	transition AlertTransition
+	attempts   int
+}
+
+var emailDigest = struct {
+	sync.Mutex
+	entries []digestEntry
+}{}
+
+func (n emailNotifier) Name() string {
+	return "email"
+}
+
+func (n emailNotifier) Retries() int {
+	return n.conf.Retries
+}
+
+func (n emailNotifier) Notify(transition AlertTransition) error {
+	if n.conf.Digest > 0 {
+		emailDigest.Lock()
+		emailDigest.entries = append(emailDigest.entries, digestEntry{transition: transition})
+		emailDigest.Unlock()
+		return errQueued
+	}
+	return sendAlertMail(n.conf, []AlertTransition{transition})
+}
+
+//startEmailDigest sends the collected transitions once per digest window
+func startEmailDigest() {
+	conf := Config().Email
+	if conf.Host == "" || conf.Digest <= 0 {
+		return
+	}
+
+	go func() {
+		for range time.Tick(time.Duration(conf.Digest) * time.Second) {
+			sendEmailDigest(conf)
+		}
+	}()
+}
+
+//sendEmailDigest mails the collected transitions at once. If that fails
+//they are kept for the next window until they ran out of retries.
+func sendEmailDigest(conf EmailConfig) {
+	emailDigest.Lock()
+	entries := emailDigest.entries
+	emailDigest.entries = nil
+	emailDigest.Unlock()
+	if len(entries) == 0 {
+		return
+	}
+
+	transitions := make([]AlertTransition, len(entries))
+	for i, entry := range entries {
+		transitions[i] = entry.transition
+	}
+	err := sendAlertMail(conf, transitions)
+	if err != nil {
+		fmt.Println("email digest", err)
+	}
+
+	var retry []digestEntry
+	for _, entry := range entries {
+		entry.attempts++
+		if err != nil && entry.attempts <= conf.Retries {
+			retry = append(retry, entry)
+			continue
+		}
+		delivery := AlertDelivery{
+			Transition: entry.transition.Id,
+			Channel:    "email:digest",
+			Status:     entry.transition.Status(),
+			Time:       float64(time.Now().Unix()),
+			Attempts:   entry.attempts,
+			Ok:         err == nil,
+		}
+		if err != nil {
+			delivery.Error = err.Error()
+		}
+		logDelivery(entry.transition.Rule.Name, delivery)
+	}
+	if len(retry) > 0 {
+		emailDigest.Lock()
+		emailDigest.entries = append(retry, emailDigest.entries...)
+		emailDigest.Unlock()
+	}
+}
+
+func renderEmailTemplate(name string, text string, data EmailData) (string, error) {
+	tmpl, err := template.New(name).Funcs(webhookFuncs).Parse(text)
+	if err != nil {
+		return "", err
+	}
+	var b bytes.Buffer
+	err = tmpl.Execute(&b, data)
+	return b.String(), err
+}
+
+func sendAlertMail(conf EmailConfig, transitions []AlertTransition) error {
+	subjectTemplate, bodyTemplate := conf.Subject, conf.Body
+	if subjectTemplate == "" {
+		subjectTemplate = DefaultEmailSubject
+	}
+	if bodyTemplate == "" {
+		bodyTemplate = DefaultEmailBody
+	}
+
+	data := EmailData{Transitions: transitions}
+	subject, err := renderEmailTemplate("subject", subjectTemplate, data)
+	if err != nil {
+		return err
+	}
+	body, err := renderEmailTemplate("body", bodyTemplate, data)
+	if err != nil {
+		return err
+	}
+	return sendMail(conf, strings.TrimSpace(subject), body)
+}
+
+//sendMail sends a plain text mail, STARTTLS is used if the server offers
+//it unless StartTLS is "never" and required if it is "always"
+func sendMail(conf EmailConfig, subject string, body string) error {
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(conf.Host, strconv.Itoa(conf.Port)), 10*time.Second)
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(time.Minute))
+	c, err := smtp.NewClient(conn, conf.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	if conf.StartTLS != "never" {
+		if ok, _ := c.Extension("STARTTLS"); ok {
+			if err := c.StartTLS(&tls.Config{ServerName: conf.Host}); err != nil {
+				return err
+			}
+		} else if conf.StartTLS == "always" {
+			return errors.New("smtp server does not support STARTTLS")
+		}
+	}
+
+	if conf.Username != "" {
+		if err := c.Auth(smtp.PlainAuth("", conf.Username, conf.Password, conf.Host)); err != nil {
+			return err
+		}
+	}
+
+	if err := c.Mail(conf.From); err != nil {
+		return err
+	}
+	for _, to := range conf.To {
+		if err := c.Rcpt(to); err != nil {
+			return err
+		}
+	}
+
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	var msg bytes.Buffer
+	msg.WriteString("From: " + conf.From + "\r\n")
+	msg.WriteString("To: " + strings.Join(conf.To, ", ") + "\r\n")
+	msg.WriteString("Subject: " + mime.QEncoding.Encode("utf-8", subject) + "\r\n")
+	msg.WriteString("Date: " + time.Now().Format(time.RFC1123Z) + "\r\n")
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	msg.WriteString(strings.Replace(body, "\n", "\r\n", -1))
+	if _, err := w.Write(msg.Bytes()); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"net"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+type testMail struct {
+	from string
+	to   []string
+	auth string
+	data string
+}
+
+//testSmtpServer accepts mails without STARTTLS, with reject set every
+//recipient is refused
+type testSmtpServer struct {
+	listener net.Listener
+	reject   bool
+	mails    chan testMail
+}
+
+func newTestSmtpServer(t *testing.T, reject bool) *testSmtpServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &testSmtpServer{listener: listener, reject: reject, mails: make(chan testMail, 10)}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go server.serve(textproto.NewConn(conn))
+		}
+	}()
+	return server
+}
+
+func (s *testSmtpServer) conf() EmailConfig {
+	host, port, _ := net.SplitHostPort(s.listener.Addr().String())
+	p, _ := strconv.Atoi(port)
+	return EmailConfig{Host: host, Port: p, StartTLS: "auto", From: "sensor@example.com", To: []string{"a@example.com", "b@example.com"}}
+}
+
+func (s *testSmtpServer) serve(conn *textproto.Conn) {
+	defer conn.Close()
+	var mail testMail
+	conn.PrintfLine("220 localhost ready")
+	for {
+		line, err := conn.ReadLine()
+		if err != nil {
+			return
+		}
+		command := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+		switch command {
+		case "EHLO", "HELO":
+			conn.PrintfLine("250-localhost\r\n250 AUTH PLAIN")
+		case "AUTH":
+			mail.auth = line
+			conn.PrintfLine("235 ok")
+		case "MAIL":
+			mail.from = line
+			conn.PrintfLine("250 ok")
+		case "RCPT":
+			if s.reject {
+				conn.PrintfLine("550 no such user")
+				continue
+			}
+			mail.to = append(mail.to, line)
+			conn.PrintfLine("250 ok")
+		case "DATA":
+			conn.PrintfLine("354 go ahead")
+			lines, err := conn.ReadDotLines()
+			if err != nil {
+				return
+			}
+			mail.data = strings.Join(lines, "\n")
+			s.mails <- mail
+			conn.PrintfLine("250 queued")
+		case "QUIT":
+			conn.PrintfLine("221 bye")
+			return
+		default:
+			conn.PrintfLine("502 not implemented")
+		}
+	}
+}
+
+func TestSendMail(t *testing.T) {
+	server := newTestSmtpServer(t, false)
+	defer server.listener.Close()
+
+	conf := server.conf()
+	conf.Username, conf.Password = "user", "pass"
+	if err := sendMail(conf, "Température", "line one\nline two"); err != nil {
+		t.Fatal(err)
+	}
+
+	mail := <-server.mails
+	if !strings.HasPrefix(mail.auth, "AUTH PLAIN ") {
+		t.Errorf("auth = %q", mail.auth)
+	}
+	if mail.from != "MAIL FROM:<sensor@example.com>" {
+		t.Errorf("from = %q", mail.from)
+	}
+	if len(mail.to) != 2 || mail.to[1] != "RCPT TO:<b@example.com>" {
+		t.Errorf("to = %q", mail.to)
+	}
+	for _, want := range []string{
+		"To: a@example.com, b@example.com",
+		"Subject: =?utf-8?q?Temp=C3=A9rature?=",
+		"Content-Type: text/plain; charset=utf-8",
+		"\n\nline one\nline two",
+	} {
+		if !strings.Contains(mail.data, want) {
+			t.Errorf("mail does not contain %q:\n%s", want, mail.data)
+		}
+	}
+}
+
+func TestSendMailErrors(t *testing.T) {
+	server := newTestSmtpServer(t, true)
+	defer server.listener.Close()
+
+	if err := sendMail(server.conf(), "subject", "body"); err == nil || !strings.Contains(err.Error(), "550") {
+		t.Errorf("refused recipient = %v, want 550", err)
+	}
+
+	conf := server.conf()
+	conf.StartTLS = "always"
+	if err := sendMail(conf, "subject", "body"); err == nil || !strings.Contains(err.Error(), "STARTTLS") {
+		t.Errorf("starttls always = %v, want STARTTLS unsupported", err)
+	}
+}
+
+func TestEmailDigest(t *testing.T) {
+	failing := newTestSmtpServer(t, true)
+	defer failing.listener.Close()
+
+	conf := failing.conf()
+	conf.Digest, conf.Retries = 60, 1
+	n := emailNotifier{conf}
+	for _, name := range []string{"hot", "humid"} {
+		transition := AlertTransition{Rule: AlertRule{Name: name, Series: "room"}, From: AlertPending, To: AlertFiring}
+		if err := n.Notify(transition); err != errQueued {
+			t.Fatalf("Notify in digest mode = %v, want errQueued", err)
+		}
+	}
+
+	//a failed digest is kept for the next window
+	sendEmailDigest(conf)
+	emailDigest.Lock()
+	kept := len(emailDigest.entries)
+	emailDigest.Unlock()
+	if kept != 2 {
+		t.Fatalf("%d transitions kept after a failed digest, want 2", kept)
+	}
+
+	server := newTestSmtpServer(t, false)
+	defer server.listener.Close()
+	conf = server.conf()
+	conf.Digest, conf.Retries = 60, 1
+	sendEmailDigest(conf)
+
+	mail := <-server.mails
+	if !strings.Contains(mail.data, "Subject: [goSensor] 2 alert notifications") ||
+		!strings.Contains(mail.data, "hot") || !strings.Contains(mail.data, "humid") {
+		t.Errorf("digest mail:\n%s", mail.data)
+	}
+	emailDigest.Lock()
+	kept = len(emailDigest.entries)
+	emailDigest.Unlock()
+	if kept != 0 {
+		t.Errorf("%d transitions kept after the digest was sent", kept)
+	}
+}
+
+func TestEmailDigestRetries(t *testing.T) {
+	failing := newTestSmtpServer(t, true)
+	defer failing.listener.Close()
+
+	conf := failing.conf()
+	conf.Digest, conf.Retries = 60, 1
+	emailNotifier{conf}.Notify(AlertTransition{Rule: AlertRule{Name: "cold"}, To: AlertFiring})
+
+	for i, want := range []int{1, 0} {
+		sendEmailDigest(conf)
+		emailDigest.Lock()
+		kept := len(emailDigest.entries)
+		emailDigest.Unlock()
+		if kept != want {
+			t.Errorf("window %d: %d transitions kept, want %d", i, kept, want)
+		}
+	}
+}
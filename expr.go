@@ -0,0 +1,312 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"unicode"
+)
+
+//A small arithmetic expression language for derived and virtual series:
+//numbers, variables (field or series.field), + - * / with the usual
+//precedence, unary minus, parentheses and the functions in exprFuncs.
+
+type exprNode interface {
+	eval(vars func(name string) (float64, bool)) (float64, error)
+}
+
+type exprNumber float64
+
+type exprVar string
+
+type exprUnary struct {
+	x exprNode
+}
+
+type exprBinary struct {
+	op   byte
+	x, y exprNode
+}
+
+type exprCall struct {
+	name string
+	args []exprNode
+}
+
+type exprFunc struct {
+	args int //-1 for one or more
+	fn   func(args []float64) float64
+}
+
+var exprFuncs = map[string]exprFunc{
+	"dew_point":         {2, func(a []float64) float64 { return dewPoint(a[0], a[1]) }},
+	"heat_index":        {2, func(a []float64) float64 { return heatIndex(a[0], a[1]) }},
+	"absolute_humidity": {2, func(a []float64) float64 { return absoluteHumidity(a[0], a[1]) }},
+	"abs":               {1, func(a []float64) float64 { return math.Abs(a[0]) }},
+	"sqrt":              {1, func(a []float64) float64 { return math.Sqrt(a[0]) }},
+	"round":             {2, func(a []float64) float64 { p := math.Pow(10, a[1]); return math.Round(a[0]*p) / p }},
+	"min": {-1, func(a []float64) float64 {
+		m := a[0]
+		for _, v := range a[1:] {
+			m = math.Min(m, v)
+		}
+		return m
+	}},
+	"max": {-1, func(a []float64) float64 {
+		m := a[0]
+		for _, v := range a[1:] {
+			m = math.Max(m, v)
+		}
+		return m
+	}},
+	"avg": {-1, func(a []float64) float64 {
+		sum := 0.0
+		for _, v := range a {
+			sum += v
+		}
+		return sum / float64(len(a))
+	}},
+}
+
+//dewPoint in °C from the temperature in °C and the relative humidity in %
+//(Magnus formula)
+func dewPoint(t float64, rh float64) float64 {
+	const a, b = 17.62, 243.12
+	gamma := math.Log(rh/100) + a*t/(b+t)
+	return b * gamma / (a - gamma)
+}
+
+//heatIndex in °C from the temperature in °C and the relative humidity in %
+//(NOAA, Rothfusz regression with its adjustments)
+func heatIndex(t float64, rh float64) float64 {
+	f := t*9/5 + 32
+	hi := 0.5 * (f + 61 + (f-68)*1.2 + rh*0.094)
+	if (hi+f)/2 >= 80 {
+		hi = -42.379 + 2.04901523*f + 10.14333127*rh - 0.22475541*f*rh - 0.00683783*f*f -
+			0.05481717*rh*rh + 0.00122874*f*f*rh + 0.00085282*f*rh*rh - 0.00000199*f*f*rh*rh
+		if rh < 13 && f >= 80 && f <= 112 {
+			hi -= (13 - rh) / 4 * math.Sqrt((17-math.Abs(f-95))/17)
+		} else if rh > 85 && f >= 80 && f <= 87 {
+			hi += (rh - 85) / 10 * (87 - f) / 5
+		}
+	}
+	return (hi - 32) * 5 / 9
+}
+
+//absoluteHumidity in g/m³ from the temperature in °C and the relative humidity in %
+func absoluteHumidity(t float64, rh float64) float64 {
+	return 6.112 * math.Exp(17.67*t/(t+243.5)) * rh * 2.1674 / (273.15 + t)
+}
+
+func (n exprNumber) eval(vars func(string) (float64, bool)) (float64, error) {
+	return float64(n), nil
+}
+
+func (n exprVar) eval(vars func(string) (float64, bool)) (float64, error) {
+	if v, ok := vars(string(n)); ok {
+		return v, nil
+	}
+	return 0, fmt.Errorf("%s has no value", string(n))
+}
+
+func (n exprUnary) eval(vars func(string) (float64, bool)) (float64, error) {
+	x, err := n.x.eval(vars)
+	return -x, err
+}
+
+func (n exprBinary) eval(vars func(string) (float64, bool)) (float64, error) {
+	x, err := n.x.eval(vars)
+	if err != nil {
+		return 0, err
+	}
+	y, err := n.y.eval(vars)
+	if err != nil {
+		return 0, err
+	}
+	switch n.op {
+	case '+':
+		return x + y, nil
+	case '-':
+		return x - y, nil
+	case '*':
+		return x * y, nil
+	}
+	if y == 0 {
+		return 0, errors.New("division by zero")
+	}
+	return x / y, nil
+}
+
+func (n exprCall) eval(vars func(string) (float64, bool)) (float64, error) {
+	args := make([]float64, len(n.args))
+	for i, arg := range n.args {
+		v, err := arg.eval(vars)
+		if err != nil {
+			return 0, err
+		}
+		args[i] = v
+	}
+	v := exprFuncs[n.name].fn(args)
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return 0, fmt.Errorf("%s is not a number", n.name)
+	}
+	return v, nil
+}
+
+//exprVars returns the variables an expression refers to
+func exprVars(node exprNode) []string {
+	switch n := node.(type) {
+	case exprVar:
+		return []string{string(n)}
+	case exprUnary:
+		return exprVars(n.x)
+	case exprBinary:
+		return append(exprVars(n.x), exprVars(n.y)...)
+	case exprCall:
+		var vars []string
+		for _, arg := range n.args {
+			vars = append(vars, exprVars(arg)...)
+		}
+		return vars
+	}
+	return nil
+}
+
+type exprParser struct {
+	src string
+	pos int
+}
+
+func parseExpr(src string) (exprNode, error) {
+	p := &exprParser{src: src}
+	node, err := p.parseSum()
+	if err != nil {
+		return nil, err
+	}
+	p.skipSpace()
+	if p.pos < len(p.src) {
+		return nil, fmt.Errorf("unexpected %q at %d", p.src[p.pos], p.pos)
+	}
+	return node, nil
+}
+
+func (p *exprParser) skipSpace() {
+	for p.pos < len(p.src) && unicode.IsSpace(rune(p.src[p.pos])) {
+		p.pos++
+	}
+}
+
+func (p *exprParser) peek() byte {
+	p.skipSpace()
+	if p.pos < len(p.src) {
+		return p.src[p.pos]
+	}
+	return 0
+}
+
+func (p *exprParser) parseSum() (exprNode, error) {
+	x, err := p.parseProduct()
+	for err == nil && (p.peek() == '+' || p.peek() == '-') {
+		op := p.src[p.pos]
+		p.pos++
+		var y exprNode
+		y, err = p.parseProduct()
+		x = exprBinary{op, x, y}
+	}
+	return x, err
+}
+
+func (p *exprParser) parseProduct() (exprNode, error) {
+	x, err := p.parseUnary()
+	for err == nil && (p.peek() == '*' || p.peek() == '/') {
+		op := p.src[p.pos]
+		p.pos++
+		var y exprNode
+		y, err = p.parseUnary()
+		x = exprBinary{op, x, y}
+	}
+	return x, err
+}
+
+func (p *exprParser) parseUnary() (exprNode, error) {
+	if p.peek() == '-' {
+		p.pos++
+		x, err := p.parseUnary()
+		return exprUnary{x}, err
+	}
+	return p.parseOperand()
+}
+
+func isIdentByte(c byte, first bool) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (!first && (c == '.' || (c >= '0' && c <= '9')))
+}
+
+func (p *exprParser) parseOperand() (exprNode, error) {
+	c := p.peek()
+	switch {
+	case c == '(':
+		p.pos++
+		x, err := p.parseSum()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek() != ')' {
+			return nil, fmt.Errorf("missing ) at %d", p.pos)
+		}
+		p.pos++
+		return x, nil
+	case c == '.' || (c >= '0' && c <= '9'):
+		start := p.pos
+		for p.pos < len(p.src) && (p.src[p.pos] == '.' || (p.src[p.pos] >= '0' && p.src[p.pos] <= '9')) {
+			p.pos++
+		}
+		v, err := strconv.ParseFloat(p.src[start:p.pos], 64)
+		return exprNumber(v), err
+	case isIdentByte(c, true):
+		start := p.pos
+		for p.pos < len(p.src) && isIdentByte(p.src[p.pos], p.pos == start) {
+			p.pos++
+		}
+		name := p.src[start:p.pos]
+		if p.peek() != '(' {
+			return exprVar(name), nil
+		}
+		return p.parseCall(name)
+	case c == 0:
+		return nil, errors.New("unexpected end of expression")
+	}
+	return nil, fmt.Errorf("unexpected %q at %d", c, p.pos)
+}
+
+func (p *exprParser) parseCall(name string) (exprNode, error) {
+	fn, ok := exprFuncs[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown function %s", name)
+	}
+	p.pos++ //(
+
+	call := exprCall{name: name}
+	if p.peek() != ')' {
+		for {
+			arg, err := p.parseSum()
+			if err != nil {
+				return nil, err
+			}
+			call.args = append(call.args, arg)
+			if p.peek() != ',' {
+				break
+			}
+			p.pos++
+		}
+	}
+	if p.peek() != ')' {
+		return nil, fmt.Errorf("missing ) at %d", p.pos)
+	}
+	p.pos++
+
+	if (fn.args >= 0 && len(call.args) != fn.args) || len(call.args) == 0 {
+		return nil, fmt.Errorf("%s: wrong number of arguments", name)
+	}
+	return call, nil
+}
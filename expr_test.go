@@ -0,0 +1,81 @@
+package main
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestParseExpr(t *testing.T) {
+	vars := map[string]float64{"t": 20, "rh": 50, "nas.cpu": 40}
+	lookup := func(name string) (float64, bool) {
+		v, ok := vars[name]
+		return v, ok
+	}
+	tests := []struct {
+		src  string
+		want float64
+	}{
+		{"1 + 2 * 3", 7},
+		{"(1 + 2) * 3", 9},
+		{"10 - 4 - 3", 3},
+		{"8 / 4 / 2", 1},
+		{"-t + 5", -15},
+		{"--2", 2},
+		{"nas.cpu / 2", 20},
+		{"round(dew_point(t, rh), 1)", 9.3},
+		{"abs(-3) + sqrt(16)", 7},
+		{"min(t, rh, 3)", 3},
+		{"max(t, rh)", 50},
+		{"avg(t, rh, nas.cpu)", 110.0 / 3},
+	}
+	for _, test := range tests {
+		node, err := parseExpr(test.src)
+		if err != nil {
+			t.Errorf("parseExpr(%q): %s", test.src, err)
+			continue
+		}
+		got, err := node.eval(lookup)
+		if err != nil {
+			t.Errorf("eval(%q): %s", test.src, err)
+			continue
+		}
+		if math.Abs(got-test.want) > 1e-9 {
+			t.Errorf("eval(%q) = %v, want %v", test.src, got, test.want)
+		}
+	}
+}
+
+func TestParseExprErrors(t *testing.T) {
+	for _, src := range []string{"", "1 +", "(1 + 2", "1 2", "foo(1)", "round(1)", "t $ 2"} {
+		if _, err := parseExpr(src); err == nil {
+			t.Errorf("parseExpr(%q) did not fail", src)
+		}
+	}
+}
+
+func TestExprEvalErrors(t *testing.T) {
+	lookup := func(name string) (float64, bool) {
+		return 0, name == "zero"
+	}
+	for _, src := range []string{"missing + 1", "1 / zero", "sqrt(0 - 1)"} {
+		node, err := parseExpr(src)
+		if err != nil {
+			t.Errorf("parseExpr(%q): %s", src, err)
+			continue
+		}
+		if _, err := node.eval(lookup); err == nil {
+			t.Errorf("eval(%q) did not fail", src)
+		}
+	}
+}
+
+func TestExprVars(t *testing.T) {
+	node, err := parseExpr("dew_point(a.t, b) * 2 - c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := exprVars(node), []string{"a.t", "b", "c"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("exprVars = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+)
+
+//FlaggedField lists the fields of a reading that failed a filter with the
+//flag action, by the name of the filter
+const FlaggedField = "flagged"
+
+const (
+	FilterReject = "reject"
+	FilterFlag   = "flag"
+)
+
+//filterState is the recent history of a series field
+type filterState struct {
+	window   []float64 //latest values including rejected ones, the median is robust against them
+	last     float64   //latest stored value
+	lastTime float64
+}
+
+var filterStates = struct {
+	sync.Mutex
+	states map[string]*filterState
+}{states: map[string]*filterState{}}
+
+func median(values []float64) float64 {
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	middle := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return (sorted[middle-1] + sorted[middle]) / 2
+	}
+	return sorted[middle]
+}
+
+//loadFilterState seeds the history of a series field from the stored readings
+func loadFilterState(name string, field string, size int) *filterState {
+	return newFilterState(latestReadings(RedisDataKeyPrefix+name, int64(size)+1), field, size)
+}
+
+//newFilterState is the history of field in readings (oldest first), flagged
+//values were never accepted and are left out
+func newFilterState(readings []map[string]interface{}, field string, size int) *filterState {
+	state := &filterState{}
+	for _, data := range readings {
+		if value, ok := readingValue(data, field); ok {
+			state.window = append(state.window, value)
+			state.last = value
+			state.lastTime = uploadAddTime(data)
+		}
+	}
+	if len(state.window) > size {
+		state.window = state.window[len(state.window)-size:]
+	}
+	return state
+}
+
+//check returns the name of the first filter the value fails, "" if none
+func (f OutlierFilter) check(value float64, addTime float64, state *filterState) string {
+	if (f.Min != nil && value < *f.Min) || (f.Max != nil && value > *f.Max) {
+		return "bounds"
+	}
+	//backfilled readings are only checked against the bounds
+	if addTime < state.lastTime {
+		return ""
+	}
+
+	if f.MaxDelta > 0 && state.lastTime > 0 {
+		//the allowed change grows with the time since the last stored value,
+		//so a real step is not rejected forever
+		allowed := f.MaxDelta * math.Max(1, (addTime-state.lastTime)/PointInterval)
+		if math.Abs(value-state.last) > allowed {
+			return "max_delta"
+		}
+	}
+
+	if f.Window > 0 && len(state.window) >= f.Window {
+		m := median(state.window)
+		if f.MaxDeviation > 0 && math.Abs(value-m) > f.MaxDeviation {
+			return "median"
+		}
+		if f.Sigmas > 0 {
+			deviations := make([]float64, len(state.window))
+			for i, v := range state.window {
+				deviations[i] = math.Abs(v - m)
+			}
+			//1.4826 scales the MAD to the standard deviation of normal data,
+			//a window of identical values has no spread to judge by
+			mad := 1.4826 * median(deviations)
+			if mad > 0 && math.Abs(value-m) > f.Sigmas*mad {
+				return "hampel"
+			}
+		}
+	}
+	return ""
+}
+
+func (f OutlierFilter) historySize() int {
+	if f.Window > 0 {
+		return f.Window
+	}
+	return 1
+}
+
+//filterReading runs the outlier filters of a series on a reading before it
+//is stored. Rejected fields are removed and flagged ones listed under
+//FlaggedField. It returns an error listing the rejected fields if nothing
+//measured is left to store.
+func filterReading(name string, data map[string]interface{}) error {
+	filters := Config().Filters[name]
+	if len(filters) == 0 {
+		return nil
+	}
+	addTime := uploadAddTime(data)
+
+	filterStates.Lock()
+	defer filterStates.Unlock()
+
+	var rejected []fieldError
+	flagged, ok := data[FlaggedField].(map[string]interface{})
+	if !ok {
+		flagged = make(map[string]interface{})
+	}
+	for field, f := range filters {
+		value, ok := data[field].(float64)
+		if !ok {
+			continue
+		}
+		key := name + "." + field
+		state, ok := filterStates.states[key]
+		if !ok {
+			state = loadFilterState(name, field, f.historySize())
+			filterStates.states[key] = state
+		}
+
+		failed := f.check(value, addTime, state)
+		if addTime >= state.lastTime {
+			state.window = append(state.window, value)
+			if len(state.window) > f.historySize() {
+				state.window = state.window[len(state.window)-f.historySize():]
+			}
+		}
+		if failed == "" {
+			if addTime >= state.lastTime {
+				state.last = value
+				state.lastTime = addTime
+			}
+			continue
+		}
+
+		action := f.Action
+		if action == "" {
+			action = FilterReject
+		}
+		metricAdd("gosensor_filtered_readings_total", 1, "sensor", name, "field", field, "filter", failed, "action", action)
+		fmt.Println("filter", name, field, value, failed, action)
+		if action == FilterFlag {
+			flagged[field] = failed
+			continue
+		}
+		delete(data, field)
+		rejected = append(rejected, fieldError{Field: field, Error: "outlier (" + failed + ")", Value: value})
+	}
+	if len(flagged) > 0 {
+		data[FlaggedField] = flagged
+	}
+	if len(rejected) == 0 {
+		return nil
+	}
+
+	for field, value := range data {
+		if _, ok := value.(float64); ok && field != "add_time" {
+			return nil
+		}
+	}
+	sort.Slice(rejected, func(i, j int) bool {
+		return rejected[i].Field < rejected[j].Field
+	})
+	return &uploadError{Status: 422, Message: "rejected by the outlier filters", Chip: name, Fields: rejected}
+}
+
+//isFlagged reports whether a stored reading flagged field as an outlier
+func isFlagged(data map[string]interface{}, field string) bool {
+	flagged, _ := data[FlaggedField].(map[string]interface{})
+	_, ok := flagged[field]
+	return ok
+}
+
+//readingValue returns a numeric field of a reading unless it is flagged as
+//an outlier, flagged values are kept for reference only
+func readingValue(data map[string]interface{}, field string) (float64, bool) {
+	value, ok := data[field].(float64)
+	return value, ok && !isFlagged(data, field)
+}
+
+//withoutFlagged returns a copy of a reading without its flagged fields for
+//the sinks
+func withoutFlagged(data map[string]interface{}) map[string]interface{} {
+	if _, ok := data[FlaggedField]; !ok {
+		return data
+	}
+	clean := make(map[string]interface{}, len(data))
+	for field, value := range data {
+		if !isFlagged(data, field) {
+			clean[field] = value
+		}
+	}
+	return clean
+}
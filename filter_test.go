@@ -0,0 +1,93 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestOutlierFilterCheck(t *testing.T) {
+	min, max := -40.0, 85.0
+	bounds := OutlierFilter{Min: &min, Max: &max}
+	delta := OutlierFilter{MaxDelta: 2}
+	median := OutlierFilter{Window: 5, MaxDeviation: 3}
+	hampel := OutlierFilter{Window: 5, Sigmas: 3}
+
+	history := &filterState{window: []float64{20, 21, 20, 22, 21}, last: 21, lastTime: 6000}
+	flat := &filterState{window: []float64{20, 20, 20, 20, 20}, last: 20, lastTime: 6000}
+	short := &filterState{window: []float64{20}, last: 20, lastTime: 6000}
+
+	tests := []struct {
+		name    string
+		filter  OutlierFilter
+		value   float64
+		addTime float64
+		state   *filterState
+		want    string
+	}{
+		{"in bounds", bounds, 20, 6600, history, ""},
+		{"below min", bounds, -50, 6600, history, "bounds"},
+		{"above max", bounds, 90, 6600, history, "bounds"},
+		{"backfill checks bounds only", delta, 100, 5400, history, ""},
+		{"small step", delta, 22.5, 6600, history, ""},
+		{"big step", delta, 30, 6600, history, "max_delta"},
+		{"step after a long gap", delta, 30, 6000 + 5*PointInterval, history, ""},
+		{"first value", delta, 30, 6600, &filterState{}, ""},
+		{"near the median", median, 23, 6600, history, ""},
+		{"far from the median", median, 30, 6600, history, "median"},
+		{"window not full", median, 30, 6600, short, ""},
+		{"within the MAD", hampel, 23, 6600, history, ""},
+		{"outside the MAD", hampel, 26, 6600, history, "hampel"},
+		{"no spread", hampel, 26, 6600, flat, ""},
+	}
+	for _, test := range tests {
+		if got := test.filter.check(test.value, test.addTime, test.state); got != test.want {
+			t.Errorf("%s: check(%v) = %q, want %q", test.name, test.value, got, test.want)
+		}
+	}
+}
+
+func TestReadingValue(t *testing.T) {
+	data := map[string]interface{}{
+		"add_time":   6000.0,
+		"temp":       20.0,
+		"humidity":   300.0,
+		FlaggedField: map[string]interface{}{"humidity": "bounds"},
+	}
+	if v, ok := readingValue(data, "temp"); !ok || v != 20 {
+		t.Errorf("readingValue(temp) = %v, %v", v, ok)
+	}
+	if _, ok := readingValue(data, "humidity"); ok {
+		t.Error("readingValue(humidity) returned a flagged value")
+	}
+	if _, ok := readingValue(data, "pressure"); ok {
+		t.Error("readingValue(pressure) returned a missing value")
+	}
+
+	want := map[string]interface{}{"add_time": 6000.0, "temp": 20.0, FlaggedField: data[FlaggedField]}
+	if got := withoutFlagged(data); !reflect.DeepEqual(got, want) {
+		t.Errorf("withoutFlagged = %v, want %v", got, want)
+	}
+	if _, ok := data["humidity"]; !ok {
+		t.Error("withoutFlagged changed the reading")
+	}
+}
+
+func TestNewFilterState(t *testing.T) {
+	readings := []map[string]interface{}{
+		{"add_time": 6000.0, "temp": 20.0},
+		{"add_time": 6600.0, "temp": 21.0},
+		{"add_time": 7200.0, "humidity": 40.0},
+		{"add_time": 7800.0, "temp": 85.0, FlaggedField: map[string]interface{}{"temp": "max_delta"}},
+	}
+	state := newFilterState(readings, "temp", 5)
+	if !reflect.DeepEqual(state.window, []float64{20, 21}) || state.last != 21 || state.lastTime != 6600 {
+		t.Errorf("state = %+v, want the flagged spike left out", state)
+	}
+	if failed := (OutlierFilter{MaxDelta: 2}).check(22, 8400, state); failed != "" {
+		t.Errorf("a normal reading after a flagged spike failed %s", failed)
+	}
+
+	if state := newFilterState(readings, "temp", 1); !reflect.DeepEqual(state.window, []float64{21}) {
+		t.Errorf("window = %v, want the latest value only", state.window)
+	}
+}
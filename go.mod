@@ -3,6 +3,7 @@ module github.com/chenset/goSensor
 go 1.12
 
 require (
+	github.com/eclipse/paho.mqtt.golang v1.2.0
 	github.com/go-redis/redis v6.15.2+incompatible
 	golang.org/x/crypto v0.0.0-20190426145343-a29dc8fdc734
 )
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var graphiteNameReplacer = strings.NewReplacer(".", "_", " ", "_", "/", "_")
+
+//graphiteLines formats a reading as Carbon plaintext lines
+func graphiteLines(name string, data map[string]interface{}) []string {
+	timestamp := strconv.FormatInt(int64(uploadAddTime(data)), 10)
+	template := Config().Graphite.Template
+
+	var lines []string
+	for field, value := range data {
+		number, ok := value.(float64)
+		if !ok || field == "add_time" {
+			continue
+		}
+		path := strings.Replace(template, "{sensor}", graphiteNameReplacer.Replace(name), -1)
+		path = strings.Replace(path, "{field}", graphiteNameReplacer.Replace(field), -1)
+		lines = append(lines, path+" "+strconv.FormatFloat(number, 'f', -1, 64)+" "+timestamp+"\n")
+	}
+	return lines
+}
+
+//graphiteSink forwards readings to a Carbon plaintext endpoint, the
+//connection is kept open between writes
+type graphiteSink struct {
+	conn net.Conn
+}
+
+func (sink *graphiteSink) Write(name string, data map[string]interface{}) error {
+	var err error
+	if sink.conn == nil {
+		sink.conn, err = net.DialTimeout("tcp", Config().Graphite.Address, 5*time.Second)
+		if err != nil {
+			sink.conn = nil
+			return err
+		}
+	}
+
+	sink.conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+	_, err = sink.conn.Write([]byte(strings.Join(graphiteLines(name, data), "")))
+	if err != nil {
+		sink.conn.Close()
+		sink.conn = nil
+	}
+	return err
+}
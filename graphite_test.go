@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bufio"
+	"net"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestGraphiteLines(t *testing.T) {
+	conf := Config()
+	saved := conf.Graphite
+	defer func() { conf.Graphite = saved }()
+
+	tests := []struct {
+		template string
+		name     string
+		data     map[string]interface{}
+		want     []string
+	}{
+		{
+			"home.{sensor}.{field}",
+			"room",
+			map[string]interface{}{"add_time": 1600000000.0, "temp": 21.5, "humidity": 40.0, "chip": "esp", "open": true},
+			[]string{"home.room.humidity 40 1600000000\n", "home.room.temp 21.5 1600000000\n"},
+		},
+		{
+			"{sensor}.{field}",
+			"living room/1.2",
+			map[string]interface{}{"add_time": int64(1600000000), "cpu.load": 0.25},
+			[]string{"living_room_1_2.cpu_load 0.25 1600000000\n"},
+		},
+		{"home.{sensor}.{field}", "room", map[string]interface{}{"add_time": 1600000000.0}, nil},
+	}
+	for _, test := range tests {
+		conf.Graphite.Template = test.template
+		got := graphiteLines(test.name, test.data)
+		sort.Strings(got)
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("graphiteLines(%q) = %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestGraphiteSink(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	lines := make(chan string, 10)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		scanner := bufio.NewScanner(conn)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+	}()
+
+	conf := Config()
+	saved := conf.Graphite
+	defer func() { conf.Graphite = saved }()
+	conf.Graphite = GraphiteConfig{Address: listener.Addr().String(), Template: "home.{sensor}.{field}"}
+
+	//the connection is kept open between writes
+	sink := &graphiteSink{}
+	for _, temp := range []float64{21, 22} {
+		if err := sink.Write("room", map[string]interface{}{"add_time": 1600000000.0, "temp": temp}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sink.conn.Close()
+	for _, want := range []string{"home.room.temp 21 1600000000", "home.room.temp 22 1600000000"} {
+		if got := <-lines; got != want {
+			t.Errorf("received %q, want %q", got, want)
+		}
+	}
+}
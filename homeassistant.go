@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+var haUnits = map[string][2]string{
+	//unit => unit_of_measurement, device_class
+	"Degrees": {"°C", "temperature"},
+	"Percent": {"%", "humidity"},
+}
+
+//haDiscoveryConfigs builds the Home Assistant MQTT discovery config of every
+//series, keyed by the discovery topic.
+func haDiscoveryConfigs() map[string]map[string]interface{} {
+	conf := Config().Mqtt
+	configs := make(map[string]map[string]interface{})
+
+	series := sensorSeries()
+	for k, v := range derivedSeries() {
+		series[k] = v
+	}
+	for key, item := range series {
+		redisKey, _ := item["redis_key"].(string)
+		index, _ := item["index"].(string)
+		unit, _ := item["unit"].(string)
+		name, _ := item["name"].(string)
+		seriesName := strings.TrimPrefix(redisKey, RedisDataKeyPrefix)
+
+		uniqueId := conf.ClientId + "_" + key
+		payload := map[string]interface{}{
+			"name":      name,
+			"unique_id": uniqueId,
+			"device": map[string]interface{}{
+				"identifiers":  []string{conf.ClientId},
+				"name":         conf.ClientId,
+				"manufacturer": "goSensor",
+			},
+		}
+
+		stateTopic := strings.Replace(conf.Publish.Topic, "{name}", seriesName, -1)
+		if strings.Contains(stateTopic, "{field}") {
+			payload["state_topic"] = strings.Replace(stateTopic, "{field}", index, -1)
+		} else {
+			payload["state_topic"] = stateTopic
+			payload["value_template"] = "{{ value_json['" + index + "'] }}"
+		}
+
+		if haUnit, ok := haUnits[unit]; ok {
+			payload["unit_of_measurement"] = haUnit[0]
+			payload["device_class"] = haUnit[1]
+		}
+		if conf.Publish.AvailabilityTopic != "" {
+			payload["availability_topic"] = conf.Publish.AvailabilityTopic
+		}
+
+		topic := conf.Discovery.Prefix + "/sensor/" + conf.ClientId + "/" + key + "/config"
+		configs[topic] = payload
+	}
+
+	return configs
+}
+
+//haDiscover publishes the retained discovery configs, called on every connect
+//so Home Assistant picks up new series after a restart
+func haDiscover(client mqtt.Client) {
+	conf := Config().Mqtt
+	if !conf.Discovery.Enabled || !conf.Publish.Enabled {
+		return
+	}
+
+	for topic, payload := range haDiscoveryConfigs() {
+		byteStr, err := json.Marshal(payload)
+		if err != nil {
+			fmt.Println(err)
+			continue
+		}
+		token := client.Publish(topic, MqttQos, true, byteStr)
+		if token.Wait() && token.Error() != nil {
+			fmt.Println("mqtt discovery", topic, token.Error())
+		}
+	}
+}
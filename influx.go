@@ -0,0 +1,232 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//influxPoint is a single parsed line of the InfluxDB line protocol
+type influxPoint struct {
+	Measurement string
+	Tags        map[string]string
+	Fields      map[string]interface{}
+	Time        time.Time
+}
+
+var influxPrecisions = map[string]time.Duration{
+	"":   time.Nanosecond,
+	"n":  time.Nanosecond,
+	"ns": time.Nanosecond,
+	"u":  time.Microsecond,
+	"us": time.Microsecond,
+	"ms": time.Millisecond,
+	"s":  time.Second,
+	"m":  time.Minute,
+	"h":  time.Hour,
+}
+
+//splitUnescaped splits s on sep, ignoring escaped separators and, if
+//quotes is set, separators inside double quoted strings.
+func splitUnescaped(s string, sep byte, quotes bool, limit int) []string {
+	var parts []string
+	inQuote := false
+	last := 0
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '\\':
+			i++
+		case quotes && s[i] == '"':
+			inQuote = !inQuote
+		case s[i] == sep && !inQuote:
+			if limit > 0 && len(parts) == limit-1 {
+				continue
+			}
+			parts = append(parts, s[last:i])
+			last = i + 1
+		}
+	}
+	return append(parts, s[last:])
+}
+
+var influxUnescaper = strings.NewReplacer(`\,`, ",", `\ `, " ", `\=`, "=", `\"`, `"`, `\\`, `\`)
+
+func parseInfluxFieldValue(value string) (interface{}, error) {
+	if value == "" {
+		return nil, errors.New("missing field value")
+	}
+	switch {
+	case value[0] == '"':
+		if len(value) < 2 || value[len(value)-1] != '"' {
+			return nil, errors.New("unterminated string field value")
+		}
+		return influxUnescaper.Replace(value[1 : len(value)-1]), nil
+	case value == "t" || value == "T" || value == "true" || value == "True" || value == "TRUE":
+		return true, nil
+	case value == "f" || value == "F" || value == "false" || value == "False" || value == "FALSE":
+		return false, nil
+	case strings.HasSuffix(value, "i"):
+		i, err := strconv.ParseInt(value[:len(value)-1], 10, 64)
+		return float64(i), err
+	case strings.HasSuffix(value, "u"):
+		u, err := strconv.ParseUint(value[:len(value)-1], 10, 64)
+		return float64(u), err
+	}
+	return strconv.ParseFloat(value, 64)
+}
+
+func parseInfluxLine(line string, precision time.Duration, now time.Time) (influxPoint, error) {
+	point := influxPoint{Tags: map[string]string{}, Fields: map[string]interface{}{}, Time: now}
+
+	sections := splitUnescaped(line, ' ', true, 3)
+	if len(sections) < 2 {
+		return point, errors.New("missing fields")
+	}
+
+	//measurement and tags
+	keys := splitUnescaped(sections[0], ',', false, 0)
+	point.Measurement = influxUnescaper.Replace(keys[0])
+	if point.Measurement == "" {
+		return point, errors.New("missing measurement")
+	}
+	for _, tag := range keys[1:] {
+		kv := splitUnescaped(tag, '=', false, 2)
+		if len(kv) != 2 || kv[0] == "" || kv[1] == "" {
+			return point, fmt.Errorf("invalid tag %q", tag)
+		}
+		point.Tags[influxUnescaper.Replace(kv[0])] = influxUnescaper.Replace(kv[1])
+	}
+
+	//fields
+	for _, field := range splitUnescaped(sections[1], ',', true, 0) {
+		kv := splitUnescaped(field, '=', true, 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return point, fmt.Errorf("invalid field %q", field)
+		}
+		value, err := parseInfluxFieldValue(kv[1])
+		if err != nil {
+			return point, fmt.Errorf("invalid field %q: %s", field, err)
+		}
+		point.Fields[influxUnescaper.Replace(kv[0])] = value
+	}
+
+	//timestamp
+	if len(sections) == 3 && strings.TrimSpace(sections[2]) != "" {
+		ts, err := strconv.ParseInt(strings.TrimSpace(sections[2]), 10, 64)
+		if err != nil {
+			return point, fmt.Errorf("invalid timestamp %q", sections[2])
+		}
+		point.Time = time.Unix(0, 0).Add(time.Duration(ts) * precision)
+	}
+
+	return point, nil
+}
+
+//influxSeriesName maps a point to a goSensor series using the configured
+//template, e.g. "{host}_{measurement}".
+func influxSeriesName(point influxPoint) string {
+	name := Config().InfluxWrite.SeriesTemplate
+	if name == "" {
+		name = "{measurement}"
+	}
+	name = strings.Replace(name, "{measurement}", point.Measurement, -1)
+	for k, v := range point.Tags {
+		name = strings.Replace(name, "{"+k+"}", v, -1)
+	}
+	return name
+}
+
+//influxWrite is compatible with the InfluxDB v1 /write API so that
+//Telegraf, Tasmota etc. can push readings without any glue.
+func influxWrite(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	defer r.Body.Close()
+	if r.Method != "POST" {
+		influxError(w, 405, "method not allowed")
+		return
+	}
+
+	//Telegraf gzips its writes by default
+	var body io.Reader = r.Body
+	if r.Header.Get("Content-Encoding") == "gzip" {
+		gz, err := gzip.NewReader(r.Body)
+		if err != nil {
+			influxError(w, 400, "invalid gzip body: "+err.Error())
+			return
+		}
+		defer gz.Close()
+		body = gz
+	}
+
+	precision, ok := influxPrecisions[r.URL.Query().Get("precision")]
+	if !ok {
+		influxError(w, 400, "invalid precision "+r.URL.Query().Get("precision"))
+		return
+	}
+
+	var errs []string
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := string(bytes.TrimSpace(scanner.Bytes()))
+		if line == "" || line[0] == '#' {
+			continue
+		}
+
+		point, err := parseInfluxLine(line, precision, start)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("line %d: %s", lineNo, err))
+			continue
+		}
+
+		data := make(map[string]interface{}, len(point.Fields)+3)
+		for k, v := range point.Fields {
+			data[k] = v
+		}
+		if len(point.Tags) > 0 {
+			data["tags"] = point.Tags
+		}
+		data["chip"] = influxSeriesName(point)
+		//lines without a timestamp are timed by the server like other uploads
+		if !point.Time.Equal(start) {
+			data["add_time"] = float64(point.Time.Unix())
+		}
+
+		chip, err := prepareUpload(data)
+		if err == nil {
+			err = storeUpload(chip, data)
+		}
+		countUpload(chip, err)
+		if err != nil && err != errDuplicate {
+			errs = append(errs, fmt.Sprintf("line %d: %s", lineNo, err))
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		errs = append(errs, err.Error())
+	}
+
+	if len(errs) > 0 {
+		influxError(w, 400, "partial write: "+strings.Join(errs, "; "))
+		return
+	}
+	w.WriteHeader(204)
+	fmt.Println(time.Since(start), r.URL)
+}
+
+func influxError(w http.ResponseWriter, status int, message string) {
+	byteStr, _ := json.Marshal(map[string]string{"error": message})
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Influxdb-Error", message)
+	w.WriteHeader(status)
+	w.Write(byteStr)
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var influxKeyEscaper = strings.NewReplacer(",", `\,`, " ", `\ `, "=", `\=`)
+var influxMeasurementEscaper = strings.NewReplacer(",", `\,`, " ", `\ `)
+
+//influxLine formats a reading as a line protocol line with a timestamp in seconds
+func influxLine(name string, data map[string]interface{}) (string, bool) {
+	var fields []string
+	for field, value := range data {
+		if field == "add_time" {
+			continue
+		}
+		switch v := value.(type) {
+		case float64:
+			fields = append(fields, influxKeyEscaper.Replace(field)+"="+strconv.FormatFloat(v, 'f', -1, 64))
+		case bool:
+			fields = append(fields, influxKeyEscaper.Replace(field)+"="+strconv.FormatBool(v))
+		}
+	}
+	if len(fields) == 0 {
+		return "", false
+	}
+	sort.Strings(fields)
+
+	line := influxMeasurementEscaper.Replace(name)
+	if tags, ok := data["tags"].(map[string]string); ok {
+		var keys []string
+		for k := range tags {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			line += "," + influxKeyEscaper.Replace(k) + "=" + influxKeyEscaper.Replace(tags[k])
+		}
+	}
+	return line + " " + strings.Join(fields, ",") + " " + strconv.FormatInt(int64(uploadAddTime(data)), 10), true
+}
+
+//influxSink mirrors the stored readings into InfluxDB. Lines are sent in
+//batches, batches that still fail after the retries are spilled to disk and
+//sent again before the next batch.
+type influxSink struct {
+	batch     []string
+	lastFlush time.Time
+}
+
+func (sink *influxSink) Write(name string, data map[string]interface{}) error {
+	line, ok := influxLine(name, data)
+	if !ok {
+		return nil
+	}
+	sink.batch = append(sink.batch, line)
+	if len(sink.batch) >= Config().InfluxSink.BatchSize {
+		//the batch is spooled or quarantined by now, writing the reading
+		//again would append its line twice
+		if err := sink.flush(); err != nil {
+			fmt.Println("influx sink", err)
+		}
+	}
+	return nil
+}
+
+func (sink *influxSink) Flush() error {
+	if time.Since(sink.lastFlush) < time.Duration(Config().InfluxSink.FlushInterval)*time.Second {
+		return nil
+	}
+	return sink.flush()
+}
+
+func (sink *influxSink) flush() error {
+	err := influxSinkFlush(sink.batch)
+	sink.batch = nil
+	sink.lastFlush = time.Now()
+	return err
+}
+
+//influxRejected is a batch InfluxDB answered with a 4xx, e.g. a field type
+//conflict. Sending it again cannot succeed.
+type influxRejected struct {
+	status  string
+	message string
+}
+
+func (e *influxRejected) Error() string {
+	return e.status + " " + e.message
+}
+
+func influxSinkFlush(batch []string) error {
+	conf := Config().InfluxSink
+
+	//spilled batches go first so that the points stay in order
+	if err := influxSinkReplay(); err != nil {
+		influxSinkSpill(conf.SpoolFile, batch)
+		return err
+	}
+
+	if len(batch) == 0 {
+		return nil
+	}
+	body := []byte(strings.Join(batch, "\n") + "\n")
+	backoff := time.Second
+	for i := 0; ; i++ {
+		err := influxSinkPost(body)
+		if err == nil {
+			return nil
+		}
+		if _, ok := err.(*influxRejected); ok {
+			influxSinkQuarantine(batch, err)
+			return err
+		}
+		if i >= conf.Retries {
+			influxSinkSpill(conf.SpoolFile, batch)
+			return err
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+//influxSinkReplay sends the spool in batch_size chunks, rejected chunks are
+//quarantined. If InfluxDB cannot be reached the spool is cut down to the
+//lines not sent yet.
+func influxSinkReplay() error {
+	conf := Config().InfluxSink
+	spool, err := ioutil.ReadFile(conf.SpoolFile)
+	if err != nil || len(spool) == 0 {
+		return nil
+	}
+
+	lines := strings.Split(strings.TrimRight(string(spool), "\n"), "\n")
+	for len(lines) > 0 {
+		size := conf.BatchSize
+		if size <= 0 || size > len(lines) {
+			size = len(lines)
+		}
+		err := influxSinkPost([]byte(strings.Join(lines[:size], "\n") + "\n"))
+		if _, ok := err.(*influxRejected); ok {
+			influxSinkQuarantine(lines[:size], err)
+		} else if err != nil {
+			rest := []byte(strings.Join(lines, "\n") + "\n")
+			if len(rest) < len(spool) {
+				ioutil.WriteFile(conf.SpoolFile+".tmp", rest, 0644)
+				os.Rename(conf.SpoolFile+".tmp", conf.SpoolFile)
+			}
+			return err
+		}
+		lines = lines[size:]
+	}
+	return os.Remove(conf.SpoolFile)
+}
+
+//influxSinkQuarantine sets a rejected batch aside in spool_file.rejected so
+//it does not hold up the batches behind it
+func influxSinkQuarantine(batch []string, err error) {
+	fmt.Println("influx sink rejected", len(batch), "lines:", err)
+	influxSinkSpill(Config().InfluxSink.SpoolFile+".rejected", batch)
+}
+
+func influxSinkSpill(file string, batch []string) {
+	conf := Config().InfluxSink
+	if len(batch) == 0 || conf.SpoolFile == "" {
+		return
+	}
+	if info, err := os.Stat(file); err == nil && info.Size() > conf.SpoolMaxBytes {
+		fmt.Println("influx sink", file, "full, dropped", len(batch), "lines")
+		return
+	}
+	f, err := os.OpenFile(file, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer f.Close()
+	f.WriteString(strings.Join(batch, "\n") + "\n")
+}
+
+//influxSinkPost writes line protocol to the v1 /write or the v2 /api/v2/write API
+func influxSinkPost(body []byte) error {
+	conf := Config().InfluxSink
+
+	query := url.Values{}
+	query.Set("precision", "s")
+	path := "/write"
+	if conf.Version == 2 {
+		path = "/api/v2/write"
+		query.Set("org", conf.Org)
+		query.Set("bucket", conf.Bucket)
+	} else {
+		query.Set("db", conf.Database)
+		if conf.RetentionPolicy != "" {
+			query.Set("rp", conf.RetentionPolicy)
+		}
+	}
+
+	req, err := http.NewRequest("POST", strings.TrimRight(conf.URL, "/")+path+"?"+query.Encode(), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if conf.Token != "" {
+		req.Header.Set("Authorization", "Token "+conf.Token)
+	} else if conf.Username != "" {
+		req.SetBasicAuth(conf.Username, conf.Password)
+	}
+
+	client := http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		message, _ := ioutil.ReadAll(resp.Body)
+		if resp.StatusCode/100 == 4 {
+			return &influxRejected{resp.Status, string(message)}
+		}
+		return errors.New(resp.Status + " " + string(message))
+	}
+	return nil
+}
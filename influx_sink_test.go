@@ -0,0 +1,168 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestInfluxLine(t *testing.T) {
+	tests := []struct {
+		name string
+		data map[string]interface{}
+		want string
+		ok   bool
+	}{
+		{
+			"room",
+			map[string]interface{}{"add_time": 1600000000.0, "temp": 21.5, "humidity": 40.0},
+			"room humidity=40,temp=21.5 1600000000",
+			true,
+		},
+		{
+			"living room",
+			map[string]interface{}{"add_time": int64(1600000000), "door open": true, "chip": "esp", "tags": map[string]string{"floor": "1,2", "a=b": "c"}},
+			`living\ room,a\=b=c,floor=1\,2 door\ open=true 1600000000`,
+			true,
+		},
+		{"empty", map[string]interface{}{"add_time": 1600000000.0, "chip": "esp"}, "", false},
+	}
+	for _, test := range tests {
+		got, ok := influxLine(test.name, test.data)
+		if got != test.want || ok != test.ok {
+			t.Errorf("influxLine(%q) = %q, %v, want %q, %v", test.name, got, ok, test.want, test.ok)
+		}
+	}
+}
+
+//testInfluxServer records the bodies written, status answers each body.
+//It is the influx_sink url of the config until close is called.
+type testInfluxServer struct {
+	*httptest.Server
+	mu     sync.Mutex
+	bodies []string
+	status func(body string) int
+	close  func()
+}
+
+func newTestInfluxServer(t *testing.T) *testInfluxServer {
+	server := &testInfluxServer{status: func(string) int { return 204 }}
+	server.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		server.mu.Lock()
+		defer server.mu.Unlock()
+		status := server.status(string(b))
+		if status == 204 {
+			server.bodies = append(server.bodies, string(b))
+		}
+		w.WriteHeader(status)
+	}))
+
+	dir, err := ioutil.TempDir("", "goSensor")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf := Config()
+	saved := conf.InfluxSink
+	conf.InfluxSink = InfluxSinkConfig{
+		URL:           server.URL,
+		Database:      "test",
+		BatchSize:     2,
+		SpoolFile:     filepath.Join(dir, "spool"),
+		SpoolMaxBytes: 1 << 20,
+	}
+	server.close = func() {
+		conf.InfluxSink = saved
+		server.Server.Close()
+		os.RemoveAll(dir)
+	}
+	return server
+}
+
+func (s *testInfluxServer) answer(status func(body string) int) {
+	s.mu.Lock()
+	s.status = status
+	s.mu.Unlock()
+}
+
+func readSpool(t *testing.T, file string) string {
+	b, err := ioutil.ReadFile(file)
+	if err != nil && !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestInfluxSinkSpillAndReplay(t *testing.T) {
+	server := newTestInfluxServer(t)
+	defer server.close()
+	spool := Config().InfluxSink.SpoolFile
+	sink := &influxSink{}
+	write := func(temp float64, addTime float64) {
+		if err := sink.Write("room", map[string]interface{}{"add_time": addTime, "temp": temp}); err != nil {
+			t.Fatalf("Write = %v, want nil once spooled", err)
+		}
+	}
+
+	server.answer(func(string) int { return 503 })
+	write(1, 1)
+	write(2, 2)
+	write(3, 3)
+	write(4, 4)
+	if got, want := readSpool(t, spool), "room temp=1 1\nroom temp=2 2\nroom temp=3 3\nroom temp=4 4\n"; got != want {
+		t.Fatalf("spool = %q, want %q", got, want)
+	}
+
+	server.answer(func(string) int { return 204 })
+	write(5, 5)
+	write(6, 6)
+	want := []string{"room temp=1 1\nroom temp=2 2\n", "room temp=3 3\nroom temp=4 4\n", "room temp=5 5\nroom temp=6 6\n"}
+	if strings.Join(server.bodies, "|") != strings.Join(want, "|") {
+		t.Errorf("written = %q, want %q", server.bodies, want)
+	}
+	if _, err := os.Stat(spool); !os.IsNotExist(err) {
+		t.Errorf("spool not removed after the replay: %v", err)
+	}
+}
+
+func TestInfluxSinkRejected(t *testing.T) {
+	server := newTestInfluxServer(t)
+	defer server.close()
+	spool := Config().InfluxSink.SpoolFile
+
+	//a spool left by an outage with a batch InfluxDB refuses in the middle
+	ioutil.WriteFile(spool, []byte("room temp=1 1\nroom temp=2 2\nroom temp=true 3\nroom temp=4 4\nroom temp=5 5\n"), 0644)
+	server.answer(func(body string) int {
+		if strings.Contains(body, "true") {
+			return 400
+		}
+		return 204
+	})
+
+	if err := influxSinkFlush([]string{"room temp=6 6"}); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"room temp=1 1\nroom temp=2 2\n", "room temp=5 5\n", "room temp=6 6\n"}
+	if strings.Join(server.bodies, "|") != strings.Join(want, "|") {
+		t.Errorf("written = %q, want %q", server.bodies, want)
+	}
+	if got := readSpool(t, spool+".rejected"); got != "room temp=true 3\nroom temp=4 4\n" {
+		t.Errorf("quarantined %q", got)
+	}
+	if got := readSpool(t, spool); got != "" {
+		t.Errorf("spool = %q after the replay", got)
+	}
+
+	//rejected batches are not spooled, they would never get through
+	if err := influxSinkFlush([]string{"room temp=true 7"}); err == nil {
+		t.Error("rejected batch did not fail")
+	}
+	if got := readSpool(t, spool); got != "" {
+		t.Errorf("rejected batch spooled: %q", got)
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSplitUnescaped(t *testing.T) {
+	tests := []struct {
+		s      string
+		sep    byte
+		quotes bool
+		limit  int
+		want   []string
+	}{
+		{"a,b,c", ',', false, 0, []string{"a", "b", "c"}},
+		{`a\,b,c`, ',', false, 0, []string{`a\,b`, "c"}},
+		{`a="x,y",b=1`, ',', true, 0, []string{`a="x,y"`, "b=1"}},
+		{`a="x,y",b=1`, ',', false, 0, []string{`a="x`, `y"`, "b=1"}},
+		{"k=v=w", '=', false, 2, []string{"k", "v=w"}},
+	}
+	for _, test := range tests {
+		if got := splitUnescaped(test.s, test.sep, test.quotes, test.limit); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("splitUnescaped(%q) = %q, want %q", test.s, got, test.want)
+		}
+	}
+}
+
+func TestParseInfluxLine(t *testing.T) {
+	now := time.Unix(1500000000, 0)
+	tests := []struct {
+		line      string
+		precision time.Duration
+		want      influxPoint
+	}{
+		{
+			"cpu,host=nas temp=42.5",
+			time.Nanosecond,
+			influxPoint{"cpu", map[string]string{"host": "nas"}, map[string]interface{}{"temp": 42.5}, now},
+		},
+		{
+			"weather temp=21,humidity=40i,ok=t 1600000000",
+			time.Second,
+			influxPoint{"weather", map[string]string{}, map[string]interface{}{"temp": 21.0, "humidity": 40.0, "ok": true}, time.Unix(1600000000, 0)},
+		},
+		{
+			`my\ room,loc=a\,b note="hello, \"world\"",n=3u 1600000000000`,
+			time.Millisecond,
+			influxPoint{"my room", map[string]string{"loc": "a,b"}, map[string]interface{}{"note": `hello, "world"`, "n": 3.0}, time.Unix(1600000000, 0)},
+		},
+	}
+	for _, test := range tests {
+		got, err := parseInfluxLine(test.line, test.precision, now)
+		if err != nil {
+			t.Errorf("parseInfluxLine(%q): %s", test.line, err)
+			continue
+		}
+		if !got.Time.Equal(test.want.Time) {
+			t.Errorf("parseInfluxLine(%q) time = %s, want %s", test.line, got.Time, test.want.Time)
+		}
+		got.Time = test.want.Time
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("parseInfluxLine(%q) = %+v, want %+v", test.line, got, test.want)
+		}
+	}
+}
+
+func TestParseInfluxLineErrors(t *testing.T) {
+	for _, line := range []string{
+		"cpu",
+		",host=a temp=1",
+		"cpu,host temp=1",
+		"cpu temp",
+		"cpu temp=abc",
+		`cpu note="open`,
+		"cpu temp=1 soon",
+	} {
+		if _, err := parseInfluxLine(line, time.Second, time.Now()); err == nil {
+			t.Errorf("parseInfluxLine(%q) did not fail", line)
+		}
+	}
+}
+
+func TestInfluxWriteRequest(t *testing.T) {
+	write := func(method string, header string, body []byte) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, "/write?precision=s", bytes.NewReader(body))
+		if header != "" {
+			r.Header.Set("Content-Encoding", header)
+		}
+		w := httptest.NewRecorder()
+		influxWrite(w, r)
+		return w
+	}
+
+	if w := write("GET", "", nil); w.Code != 405 {
+		t.Errorf("GET /write = %d, want 405", w.Code)
+	}
+	if w := write("POST", "gzip", []byte("cpu temp=1")); w.Code != 400 || !strings.Contains(w.Body.String(), "gzip") {
+		t.Errorf("invalid gzip body = %d %s, want 400", w.Code, w.Body)
+	}
+
+	//only a decompressed body gets as far as the second line
+	var b bytes.Buffer
+	gz := gzip.NewWriter(&b)
+	gz.Write([]byte("# telegraf\ncpu\n"))
+	gz.Close()
+	if w := write("POST", "gzip", b.Bytes()); w.Code != 400 || !strings.Contains(w.Body.String(), "line 2: missing fields") {
+		t.Errorf("gzip body = %d %s, want line 2 to be parsed", w.Code, w.Body)
+	}
+}
@@ -11,6 +11,7 @@ import (
 	"io"
 	"io/ioutil"
 	"log"
+	"math"
 	"net"
 	"net/http"
 	"os"
@@ -26,12 +27,10 @@ import (
 
 var once sync.Once
 
-const UploadKeyPrefix = "sensor_upload_key_"
 const RedisDataKeyPrefix = "go_sensor_data_key_"
 const RedisSensorJsonKey = "sensor_json_cache_key"
 const PointInterval = 60 * 10
 const DaysRange = 31
-const RedisLeftListStart = -DaysRange * 86400 / PointInterval //lRange/lTrim等使用的start值
 
 var redisInstance *redis.Client
 var cpuNum = runtime.NumCPU()
@@ -84,6 +83,11 @@ func main() {
 		}
 	}()
 
+	Config() //fail early on a broken config.json
+	startMqtt()
+	startSinks()
+	startEmailDigest()
+
 	http.HandleFunc("/nocache/sensor.json", commonHandler(func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
 		res := sensorJsonCache()
@@ -96,8 +100,17 @@ func main() {
 	http.HandleFunc("/sensor.json", commonHandler(func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
 
-		res, err := Redis().Get(RedisSensorJsonKey).Result()
+		opts, custom, err := parseSeriesOptions(r.URL.Query())
 		if err != nil {
+			writeJsonError(w, 400, err)
+			return
+		}
+
+		var res string
+		if custom {
+			byteStr, _ := sensorJson(opts)
+			res = string(byteStr)
+		} else if res, err = Redis().Get(RedisSensorJsonKey).Result(); err != nil {
 			res = sensorJsonCache()
 		}
 
@@ -145,6 +158,17 @@ func main() {
 	}))
 
 	http.HandleFunc("/sensor/upload", sensorUpload)
+	http.HandleFunc("/sensor/upload/batch", sensorUploadBatch)
+	http.HandleFunc("/write", influxWrite)
+	http.HandleFunc("/metrics", metricsHandler)
+	http.HandleFunc("/sinks", sinksHandler)
+	http.HandleFunc("/api/silences", silencesHandler)
+	http.HandleFunc("/api/maintenance", maintenanceHandler)
+	http.HandleFunc("/api/alerts", alertsHandler)
+	http.HandleFunc("/api/alerts/history", alertHistoryHandler)
+	http.HandleFunc("/api/alerts/ack", ackHandler)
+	http.HandleFunc("/api/calibration/recompute", recalibrateHandler)
+	http.HandleFunc("/api/devices", devicesHandler)
 
 	http.HandleFunc("/static/js/jquery-2.1.1.min.js", commonHandler(func(w http.ResponseWriter, r *http.Request) {
 		//prefix := "/static"
@@ -161,6 +185,16 @@ func main() {
 		http.ServeFile(w, r, file)
 	}))
 
+	http.HandleFunc("/silences", commonHandler(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		html, err := template.ParseFiles("template/silences.html")
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		html.Execute(w, nil)
+	}))
+
 	http.HandleFunc("/", commonHandler(func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
 		w.Header().Set("Content-Type", "text/html")
@@ -179,20 +213,22 @@ func main() {
 		fmt.Println(time.Since(start), r.URL)
 	}))
 
-	err := http.ListenAndServe(":88", nil)
+	err := http.ListenAndServe(":88", instrumentHandler(http.DefaultServeMux))
 	if err != nil {
 		fmt.Println(err)
 	}
 }
 
 func sensorJsonCache() string {
-	byteStr, _ := sensorJson()
+	byteStr, _ := sensorJson(defaultSeriesOptions())
 	Redis().Set(RedisSensorJsonKey, string(byteStr), 800e9) //800s
 	return string(byteStr)
 }
 
-func sensorJson() ([]byte, error) {
-	var temperatureData = map[string]map[string]interface{}{
+//sensorSeries returns the charted series, a fresh copy on every call as
+//sensorJson fills them in place
+func sensorSeries() map[string]map[string]interface{} {
+	return map[string]map[string]interface{}{
 		"nas": {
 			"name":           "nas",
 			"redis_key":      RedisDataKeyPrefix + "nas",
@@ -360,7 +396,15 @@ func sensorJson() ([]byte, error) {
 		//	"min_time":       0,
 		//},
 	}
-	lastAddTime := 0
+}
+
+//sensorJson charts every series on the PointInterval grid, see
+//seriesOptions for the handling of gaps
+func sensorJson(opts seriesOptions) ([]byte, error) {
+	var temperatureData = sensorSeries()
+	for k, v := range derivedSeries() {
+		temperatureData[k] = v
+	}
 	for _, tempValue := range temperatureData {
 		item := tempValue
 		//if !ok {
@@ -371,19 +415,21 @@ func sensorJson() ([]byte, error) {
 		if !ok {
 			continue
 		}
-		list, _ := Redis().LRange(redisKey, RedisLeftListStart, -1).Result()
-		//fmt.Println(list)
-
-		var jsonO = make(map[string]interface{})
-		for _, jsonStr := range list {
-			json.Unmarshal([]byte(jsonStr), &jsonO)
+		item["series"] = strings.TrimPrefix(redisKey, RedisDataKeyPrefix)
+		index, _ := item["index"].(string)
 
+		points := make(map[int64]float64)
+		for _, jsonO := range storedReadings(redisKey) {
+			jsonAddTime, _ := jsonO["add_time"].(float64)
+			if _, ok := jsonO[index].(float64); !ok {
+				continue
+			}
+			//flagged outliers are charted as null and do not count for max and min
+			indexValue, ok := readingValue(jsonO, index)
 			if !ok {
+				points[gridTime(jsonAddTime)] = math.NaN()
 				continue
 			}
-			jsonAddTime, _ := jsonO["add_time"].(float64)
-			index, _ := item["index"].(string)
-			indexValue, _ := jsonO[index].(float64)
 
 			//max
 			maxValue, _ := item["max"].(float64)
@@ -399,34 +445,11 @@ func sensorJson() ([]byte, error) {
 				item["min_time"] = jsonAddTime
 			}
 
-			pointStart := item["point_start"]
-			if pointStart == 0 {
-				if _, ok := item["point_start"].(int); ok {
-					item["point_start"] = jsonAddTime
-					lastAddTime = int(jsonAddTime)
-				}
-
-				indexArr, _ := item[index].([]interface{})
-				item[index] = append(indexArr[:], indexValue)
-			}
-
-			whileFlag := 0
-			for {
-				if lastAddTime >= int(jsonAddTime) {
-					break
-				}
-
-				if whileFlag > 1 {
-					indexArr, _ := item[index].([]interface{})
-					item[index] = append(indexArr[:], nil)
-				} else {
-					indexArr, _ := item[index].([]interface{})
-					item[index] = append(indexArr[:], indexValue)
-				}
-				lastAddTime += PointInterval
-				whileFlag += 1
-			}
+			//the last reading of an interval wins
+			points[gridTime(jsonAddTime)] = indexValue
 		}
+
+		item["point_start"], item[index] = gridValues(points, opts)
 	}
 
 	//delete item if empty
@@ -447,6 +470,8 @@ func sensorJson() ([]byte, error) {
 		//}
 	}
 
+	sortData = append(sortData, virtualSeries(opts)...)
+
 	//sorted by order
 	sort.Slice(sortData, func(i, j int) bool {
 		first, _ := sortData[i]["order"].(int)
@@ -470,44 +495,31 @@ func sensorsLoop() {
 	}
 	onceLock = true
 
-	start := time.Now()
-	if res, ok := dhtSensor("one"); ok {
-		saveData("one", res)
-	}
-	fmt.Println(time.Since(start))
-	start = time.Now()
-	if res, ok := dhtSensor("two"); ok {
-		saveData("two", res)
-	}
-	fmt.Println(time.Since(start))
-	start = time.Now()
-	if res, ok := dhtSensor("three"); ok {
-		saveData("three", res)
-	}
-	fmt.Println(time.Since(start))
-	start = time.Now()
-	if res, ok := dhtSensor("four"); ok {
-		saveData("four", res)
-	}
-	fmt.Println(time.Since(start))
-	start = time.Now()
-	if res, ok := nasSensor(); ok {
-		saveData("nas", res)
-	}
-	fmt.Println(time.Since(start))
-	start = time.Now()
-	if res, ok := routeSensor(); ok {
-		saveData("route", res)
-	}
-	fmt.Println(time.Since(start))
+	collect("staleness", checkStaleness)
+	collect("nas", func() bool {
+		res, ok := nasSensor()
+		if ok {
+			saveData("nas", res)
+		}
+		return ok
+	})
+	collect("route", func() bool {
+		res, ok := routeSensor()
+		if ok {
+			saveData("route", res)
+		}
+		return ok
+	})
 	onceLock = false //同时只执行一次
 }
 
-func saveData(name string, data interface{}) {
+//saveData stores a reading of series name, readings stored before are
+//skipped with errDuplicate
+func saveData(name string, data interface{}) error {
 	saveData := make(map[string]interface{})
 	k, ok := data.(map[string]interface{})
 	if !ok {
-		return
+		return nil
 	}
 
 	saveData = k
@@ -517,18 +529,33 @@ func saveData(name string, data interface{}) {
 	if _, ok := saveData["add_time"]; !ok {
 		saveData["add_time"] = time.Now().Unix()
 	}
-
-	saveStr, err := json.Marshal(saveData)
-	if err != nil {
-		fmt.Println(err)
-		return
+	if !claimReading(name, saveData) {
+		fmt.Println("duplicate", name, saveData)
+		return errDuplicate
 	}
-	Redis().RPush(RedisDataKeyPrefix+name, string(saveStr))
 
-	//乘以2是用于冗余两倍的数据量
-	Redis().LTrim(RedisDataKeyPrefix+name, RedisLeftListStart*2, -1)
+	applyCalibration(name, saveData)
+	if err := filterReading(name, saveData); err != nil {
+		fmt.Println("rejected", name, saveData)
+		releaseReading(name, saveData)
+		return err
+	}
+	applyDerived(name, saveData)
+	//stored before anything is acknowledged or read back, the other sinks are fed asynchronously
+	if err := storeReading(name, saveData); err != nil {
+		fmt.Println("store", name, err)
+		releaseReading(name, saveData)
+		return err
+	}
+	//flagged outliers are kept in redis only
+	clean := withoutFlagged(saveData)
+	metricsReading(name, clean)
+	fanOut(name, clean)
+	touchSeries(name, saveData)
+	evaluateAlerts(name, saveData)
 
 	fmt.Println(saveData)
+	return nil
 }
 
 func routeSensor() (map[string]interface{}, bool) {
@@ -721,19 +748,6 @@ func nasSensor() (map[string]interface{}, bool) {
 	return data, true
 }
 
-func dhtSensor(chip string) (map[string]interface{}, bool) {
-	str, err := Redis().Get(UploadKeyPrefix + chip).Bytes()
-	if err != nil {
-		fmt.Println(chip + " 无数据")
-		return make(map[string]interface{}), false
-	}
-
-	jsonO := make(map[string]interface{})
-	json.Unmarshal(str, &jsonO)
-
-	return jsonO, true
-}
-
 func sensorUpload(w http.ResponseWriter, r *http.Request) {
 	start := time.Now()
 
@@ -741,7 +755,7 @@ func sensorUpload(w http.ResponseWriter, r *http.Request) {
 	b, err := ioutil.ReadAll(r.Body)
 	defer r.Body.Close()
 	if err != nil {
-		http.Error(w, err.Error(), 500)
+		writeUploadError(w, err)
 		return
 	}
 
@@ -749,45 +763,40 @@ func sensorUpload(w http.ResponseWriter, r *http.Request) {
 
 	err = json.Unmarshal(b, &jsonObj)
 	if err != nil {
-		http.Error(w, err.Error(), 500)
+		writeUploadError(w, &uploadError{Status: 400, Message: err.Error()})
 		return
 	}
 
 	data, ok := jsonObj.(map[string]interface{})
 	if !ok {
-		http.Error(w, "无法解析json", 500)
+		writeUploadError(w, &uploadError{Status: 400, Message: "expected json object"})
 		return
 	}
 
-	if _, ok = data["add_time"]; !ok {
-		data["add_time"] = time.Now().Unix()
+	//retries of the same upload carry the same key
+	if id := r.Header.Get("Idempotency-Key"); id != "" {
+		if _, ok := data["message_id"]; !ok {
+			data["message_id"] = id
+		}
 	}
 
-	chip, ok := data["chip"].(string)
-	if !ok {
-		data["chip"] = "undefined"
+	//a single reading is sent right away, so its add_time tells the device clock
+	sentAt := uploadSentAt(r, data)
+	if sentAt == 0 {
+		sentAt, _ = data["add_time"].(float64)
 	}
-
-	//validation
-	if chip == "one" || chip == "two" || chip == "three" || chip == "four" {
-		humidity, _ := data["humidity"].(float64)
-		temperature, _ := data["temperature"].(float64)
-		if humidity == 0.0 && temperature == 0.0 {
-			http.Error(w, "温度湿度不能同时等于0", 500)
-			return
-		}
+	chip, err := prepareReading(data, sentAt)
+	if err == nil {
+		err = storeUpload(chip, data)
 	}
-
-	insertStr, err := json.Marshal(data)
-	if err != nil {
-		http.Error(w, err.Error(), 500)
+	countUpload(chip, err)
+	if err == errDuplicate {
+		w.Header().Set("X-GoSensor-Duplicate", "true")
+	} else if err != nil {
+		writeUploadError(w, err)
 		return
 	}
 
-	Redis().Set(UploadKeyPrefix+data["chip"].(string), insertStr, 0)
-
-	//fmt.Println(Redis().Get(UploadKeyPrefix+data["chip"].(string)))
-	//
 	//for k, v := range data {
 	//	switch v2 := v.(type) {
 	//	case string:
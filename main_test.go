@@ -0,0 +1,20 @@
+package main
+
+import (
+	"os"
+	"testing"
+
+	"github.com/go-redis/redis"
+)
+
+//TestMain runs the tests against the default config and a redis that
+//cannot be reached, so a local config.json or redis is never touched
+func TestMain(m *testing.M) {
+	configOnce.Do(func() {
+		configInstance = defaultConfig()
+	})
+	once.Do(func() {
+		redisInstance = redis.NewClient(&redis.Options{Addr: "127.0.0.1:1"})
+	})
+	os.Exit(m.Run())
+}
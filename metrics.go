@@ -0,0 +1,231 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+//A tiny Prometheus text format registry, labels are passed as name/value pairs.
+
+var metricHelp = map[string][2]string{
+	//name => type, help
+	"gosensor_value":                         {"gauge", "Latest value of a series field."},
+	"gosensor_last_update_timestamp_seconds": {"gauge", "add_time of the latest reading of a series."},
+	"gosensor_collection_duration_seconds":   {"gauge", "Duration of the last collection run of a collector."},
+	"gosensor_collection_errors_total":       {"counter", "Failed collection runs by collector."},
+	"gosensor_uploads_total":                 {"counter", "Uploaded readings by chip and status."},
+	"gosensor_filtered_readings_total":       {"counter", "Readings rejected or flagged by the outlier filters."},
+	"gosensor_clock_skew_seconds":            {"gauge", "Measured clock skew of a device, negative if behind."},
+	"gosensor_clock_skew_readings_total":     {"counter", "Readings outside the clock skew window by chip and action."},
+	"gosensor_http_request_duration_seconds": {"histogram", "HTTP request latencies by handler."},
+}
+
+var metricBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+type histogram struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+var metrics = struct {
+	sync.Mutex
+	values     map[string]map[string]float64 //name => labels => value
+	histograms map[string]map[string]*histogram
+}{
+	values:     map[string]map[string]float64{},
+	histograms: map[string]map[string]*histogram{},
+}
+
+var metricEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func metricLabels(labels []string) string {
+	var b strings.Builder
+	for i := 0; i+1 < len(labels); i += 2 {
+		if b.Len() > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(labels[i] + `="` + metricEscaper.Replace(labels[i+1]) + `"`)
+	}
+	return b.String()
+}
+
+func metricSet(name string, value float64, labels ...string) {
+	metrics.Lock()
+	defer metrics.Unlock()
+	if metrics.values[name] == nil {
+		metrics.values[name] = map[string]float64{}
+	}
+	metrics.values[name][metricLabels(labels)] = value
+}
+
+func metricAdd(name string, value float64, labels ...string) {
+	metrics.Lock()
+	defer metrics.Unlock()
+	if metrics.values[name] == nil {
+		metrics.values[name] = map[string]float64{}
+	}
+	metrics.values[name][metricLabels(labels)] += value
+}
+
+func metricObserve(name string, value float64, labels ...string) {
+	metrics.Lock()
+	defer metrics.Unlock()
+	if metrics.histograms[name] == nil {
+		metrics.histograms[name] = map[string]*histogram{}
+	}
+	key := metricLabels(labels)
+	h, ok := metrics.histograms[name][key]
+	if !ok {
+		h = &histogram{counts: make([]uint64, len(metricBuckets))}
+		metrics.histograms[name][key] = h
+	}
+	for i, bucket := range metricBuckets {
+		if value <= bucket {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += value
+}
+
+//metricsReading records the latest values of a stored reading, backfilled
+//readings older than the latest one are skipped
+func metricsReading(name string, data map[string]interface{}) {
+	addTime := uploadAddTime(data)
+	metrics.Lock()
+	lastUpdate, ok := metrics.values["gosensor_last_update_timestamp_seconds"][metricLabels([]string{"sensor", name})]
+	metrics.Unlock()
+	if ok && addTime < lastUpdate {
+		return
+	}
+
+	for field, value := range data {
+		if number, ok := value.(float64); ok && field != "add_time" {
+			metricSet("gosensor_value", number, "sensor", name, "field", field)
+		}
+	}
+	metricSet("gosensor_last_update_timestamp_seconds", addTime, "sensor", name)
+}
+
+//countUpload counts an uploaded reading as ok, duplicate, rejected (invalid)
+//or error
+func countUpload(chip string, err error) {
+	status := "ok"
+	if err == errDuplicate {
+		status = "duplicate"
+	} else if e, ok := err.(*uploadError); ok && e.Status < 500 {
+		status = "rejected"
+	} else if err != nil {
+		status = "error"
+	}
+	if chip == "" {
+		chip = "undefined"
+	}
+	metricAdd("gosensor_uploads_total", 1, "chip", chip, "status", status)
+}
+
+//collect runs and times a collector
+func collect(collector string, fn func() bool) {
+	start := time.Now()
+	ok := fn()
+	metricSet("gosensor_collection_duration_seconds", time.Since(start).Seconds(), "collector", collector)
+	if !ok {
+		metricAdd("gosensor_collection_errors_total", 1, "collector", collector)
+	}
+	fmt.Println(time.Since(start))
+}
+
+//instrumentHandler records the latency of every request by the pattern of
+//the handler it was routed to.
+func instrumentHandler(mux *http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		_, pattern := mux.Handler(r)
+		mux.ServeHTTP(w, r)
+		metricObserve("gosensor_http_request_duration_seconds", time.Since(start).Seconds(), "handler", pattern)
+	})
+}
+
+var metricsSeedOnce sync.Once
+
+//metricsSeed loads the latest reading of every series from redis, so the
+//gauges are complete right after a restart.
+func metricsSeed() {
+	keys, err := Redis().Keys(RedisDataKeyPrefix + "*").Result()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	for _, key := range keys {
+		for _, data := range latestReadings(key, 1) {
+			metricsReading(strings.TrimPrefix(key, RedisDataKeyPrefix), withoutFlagged(data))
+		}
+	}
+}
+
+func formatMetricValue(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+func writeMetrics(out io.Writer) {
+	metrics.Lock()
+	defer metrics.Unlock()
+
+	var names []string
+	for name := range metrics.values {
+		names = append(names, name)
+	}
+	for name := range metrics.histograms {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b bytes.Buffer
+	for _, name := range names {
+		if help, ok := metricHelp[name]; ok {
+			fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s %s\n", name, help[1], name, help[0])
+		}
+
+		var keys []string
+		if values, ok := metrics.values[name]; ok {
+			for key := range values {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+			for _, key := range keys {
+				fmt.Fprintf(&b, "%s{%s} %s\n", name, key, formatMetricValue(values[key]))
+			}
+			continue
+		}
+
+		histograms := metrics.histograms[name]
+		for key := range histograms {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			h := histograms[key]
+			for i, bucket := range metricBuckets {
+				fmt.Fprintf(&b, "%s_bucket{%s,le=\"%s\"} %d\n", name, key, formatMetricValue(bucket), h.counts[i])
+			}
+			fmt.Fprintf(&b, "%s_bucket{%s,le=\"+Inf\"} %d\n", name, key, h.count)
+			fmt.Fprintf(&b, "%s_sum{%s} %s\n", name, key, formatMetricValue(h.sum))
+			fmt.Fprintf(&b, "%s_count{%s} %d\n", name, key, h.count)
+		}
+	}
+	out.Write(b.Bytes())
+}
+
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+	metricsSeedOnce.Do(metricsSeed)
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	writeMetrics(w)
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+const MqttQos = 1
+
+var mqttClient mqtt.Client
+
+//startMqtt connects to the configured broker in the background, the
+//connection is retried until it succeeds and re-established when it is lost.
+func startMqtt() {
+	conf := Config().Mqtt
+	if conf.Broker == "" {
+		return
+	}
+
+	opts := mqtt.NewClientOptions().
+		AddBroker(conf.Broker).
+		SetClientID(conf.ClientId).
+		SetUsername(conf.Username).
+		SetPassword(conf.Password).
+		SetAutoReconnect(true).
+		SetMaxReconnectInterval(time.Minute).
+		SetOnConnectHandler(func(client mqtt.Client) {
+			fmt.Println("mqtt connected", conf.Broker)
+			mqttSubscribe(client, conf.Subscriptions)
+			if conf.Publish.Enabled && conf.Publish.AvailabilityTopic != "" {
+				client.Publish(conf.Publish.AvailabilityTopic, MqttQos, true, "online")
+			}
+			go haDiscover(client)
+		}).
+		SetConnectionLostHandler(func(client mqtt.Client, err error) {
+			fmt.Println("mqtt connection lost", err)
+		})
+
+	if conf.Publish.Enabled && conf.Publish.AvailabilityTopic != "" {
+		opts.SetWill(conf.Publish.AvailabilityTopic, "offline", MqttQos, true)
+	}
+
+	mqttClient = mqtt.NewClient(opts)
+
+	go func() {
+		backoff := time.Second
+		for {
+			token := mqttClient.Connect()
+			token.Wait()
+			if token.Error() == nil {
+				return
+			}
+			fmt.Println("mqtt connect", token.Error())
+			time.Sleep(backoff)
+			if backoff < time.Minute {
+				backoff *= 2
+			}
+		}
+	}()
+}
+
+//mqttUpload stores a reading received by a subscription
+var mqttUpload = func(data map[string]interface{}) (string, error) {
+	chip, err := prepareUpload(data)
+	if err == nil {
+		err = storeUpload(chip, data)
+	}
+	return chip, err
+}
+
+//mqttSubscribe is called on every (re)connect, clean sessions drop the subscriptions
+func mqttSubscribe(client mqtt.Client, subscriptions []MqttSubscription) {
+	for _, sub := range subscriptions {
+		sub := sub
+		token := client.Subscribe(sub.Topic, MqttQos, func(client mqtt.Client, msg mqtt.Message) {
+			chip, data, err := mqttReading(sub, msg.Topic(), msg.Payload())
+			if err == nil {
+				chip, err = mqttUpload(data)
+			}
+			countUpload(chip, err)
+			if err != nil && err != errDuplicate {
+				fmt.Println("mqtt", msg.Topic(), err)
+			}
+		})
+		if token.Wait() && token.Error() != nil {
+			fmt.Println("mqtt subscribe", sub.Topic, token.Error())
+		}
+	}
+}
+
+//mqttTopicMatch returns the topic levels matched by the wildcards of
+//filter, "#" matches all remaining levels as a single value.
+func mqttTopicMatch(filter string, topic string) ([]string, bool) {
+	filterLevels := strings.Split(filter, "/")
+	topicLevels := strings.Split(topic, "/")
+
+	var wildcards []string
+	for i, level := range filterLevels {
+		if level == "#" {
+			return append(wildcards, strings.Join(topicLevels[i:], "/")), true
+		}
+		if i >= len(topicLevels) {
+			return nil, false
+		}
+		if level == "+" {
+			wildcards = append(wildcards, topicLevels[i])
+		} else if level != topicLevels[i] {
+			return nil, false
+		}
+	}
+	return wildcards, len(filterLevels) == len(topicLevels)
+}
+
+//mqttTemplate replaces {topic}, {last} and {1}..{n} (the wildcard levels)
+func mqttTemplate(template string, topic string, wildcards []string) string {
+	levels := strings.Split(topic, "/")
+	template = strings.Replace(template, "{topic}", topic, -1)
+	template = strings.Replace(template, "{last}", levels[len(levels)-1], -1)
+	for i, v := range wildcards {
+		template = strings.Replace(template, "{"+strconv.Itoa(i+1)+"}", v, -1)
+	}
+	return template
+}
+
+//mqttReading maps a message to a chip and a reading. JSON object payloads
+//are used as they are, anything else is stored as the configured field.
+func mqttReading(sub MqttSubscription, topic string, payload []byte) (string, map[string]interface{}, error) {
+	wildcards, ok := mqttTopicMatch(sub.Topic, topic)
+	if !ok {
+		return "", nil, errors.New("topic does not match " + sub.Topic)
+	}
+
+	chip := mqttTemplate(sub.Chip, topic, wildcards)
+	if chip == "" {
+		chip = mqttTemplate("{last}", topic, wildcards)
+	}
+
+	data := make(map[string]interface{})
+	if sub.Field == "" {
+		if err := json.Unmarshal(payload, &data); err == nil {
+			data["chip"] = chip
+			return chip, data, nil
+		}
+	}
+
+	field := mqttTemplate(sub.Field, topic, wildcards)
+	if field == "" {
+		field = mqttTemplate("{last}", topic, wildcards)
+	}
+
+	str := strings.TrimSpace(string(payload))
+	if value, err := strconv.ParseFloat(str, 64); err == nil {
+		data[field] = value
+	} else if value, err := strconv.ParseBool(str); err == nil {
+		data[field] = value
+	} else {
+		data[field] = str
+	}
+	data["chip"] = chip
+	return chip, data, nil
+}
+
+//mqttSink republishes the stored readings. With {field} in the topic
+//template every numeric field is published on its own topic, otherwise the
+//whole reading is published as JSON.
+type mqttSink struct {
+	latest map[string]float64 //add_time of the latest published reading by series
+}
+
+func (sink *mqttSink) Write(name string, data map[string]interface{}) error {
+	conf := Config().Mqtt.Publish
+	if !mqttClient.IsConnected() {
+		return errors.New("mqtt not connected")
+	}
+
+	//backfilled readings are published without retain, they would replace
+	//the retained latest value
+	addTime := uploadAddTime(data)
+	latest, ok := sink.latest[name]
+	if !ok {
+		for _, reading := range latestReadings(RedisDataKeyPrefix+name, 1) {
+			latest = uploadAddTime(reading)
+		}
+	}
+	retain := conf.Retain
+	if addTime < latest {
+		retain = false
+	} else {
+		sink.latest[name] = addTime
+	}
+
+	topic := strings.Replace(conf.Topic, "{name}", name, -1)
+	if !strings.Contains(topic, "{field}") {
+		payload, err := json.Marshal(data)
+		if err != nil {
+			return err
+		}
+		return mqttPublish(topic, retain, payload)
+	}
+
+	for field, value := range data {
+		if number, ok := value.(float64); ok && field != "add_time" {
+			payload := strconv.FormatFloat(number, 'f', -1, 64)
+			if err := mqttPublish(strings.Replace(topic, "{field}", field, -1), retain, payload); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func mqttPublish(topic string, retained bool, payload interface{}) error {
+	token := mqttClient.Publish(topic, MqttQos, retained, payload)
+	if !token.WaitTimeout(10 * time.Second) {
+		return errors.New("mqtt publish timeout " + topic)
+	}
+	return token.Error()
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+func TestMqttTopicMatch(t *testing.T) {
+	tests := []struct {
+		filter    string
+		topic     string
+		wildcards []string
+		ok        bool
+	}{
+		{"sensors/room/temp", "sensors/room/temp", nil, true},
+		{"sensors/+/temp", "sensors/kitchen/temp", []string{"kitchen"}, true},
+		{"+/+/temp", "sensors/kitchen/temp", []string{"sensors", "kitchen"}, true},
+		{"sensors/#", "sensors/kitchen/temp", []string{"kitchen/temp"}, true},
+		{"#", "sensors", []string{"sensors"}, true},
+		{"sensors/+/temp", "sensors/kitchen/humidity", nil, false},
+		{"sensors/+", "sensors/kitchen/temp", nil, false},
+		{"sensors/+/temp", "sensors/kitchen", nil, false},
+	}
+	for _, test := range tests {
+		wildcards, ok := mqttTopicMatch(test.filter, test.topic)
+		if ok != test.ok || (ok && !reflect.DeepEqual(wildcards, test.wildcards)) {
+			t.Errorf("mqttTopicMatch(%q, %q) = %q, %v, want %q, %v",
+				test.filter, test.topic, wildcards, ok, test.wildcards, test.ok)
+		}
+	}
+}
+
+func TestMqttReading(t *testing.T) {
+	tests := []struct {
+		sub     MqttSubscription
+		topic   string
+		payload string
+		chip    string
+		data    map[string]interface{}
+	}{
+		{
+			MqttSubscription{Topic: "home/+/+"},
+			"home/kitchen/temp", "21.5",
+			"temp", map[string]interface{}{"chip": "temp", "temp": 21.5},
+		},
+		{
+			MqttSubscription{Topic: "home/+/+", Chip: "{1}", Field: "{2}"},
+			"home/kitchen/door", " true\n",
+			"kitchen", map[string]interface{}{"chip": "kitchen", "door": true},
+		},
+		{
+			MqttSubscription{Topic: "home/#", Chip: "home_{last}", Field: "state"},
+			"home/garage/light", "on",
+			"home_light", map[string]interface{}{"chip": "home_light", "state": "on"},
+		},
+		{
+			MqttSubscription{Topic: "esp/+", Chip: "{1}"},
+			"esp/nas", `{"cpu": 40, "chip": "other"}`,
+			"nas", map[string]interface{}{"chip": "nas", "cpu": 40.0},
+		},
+	}
+	for _, test := range tests {
+		chip, data, err := mqttReading(test.sub, test.topic, []byte(test.payload))
+		if err != nil {
+			t.Errorf("mqttReading(%q): %s", test.topic, err)
+			continue
+		}
+		if chip != test.chip || !reflect.DeepEqual(data, test.data) {
+			t.Errorf("mqttReading(%q, %q) = %q, %v, want %q, %v", test.topic, test.payload, chip, data, test.chip, test.data)
+		}
+	}
+
+	if _, _, err := mqttReading(MqttSubscription{Topic: "home/+"}, "office/desk", []byte("1")); err == nil {
+		t.Error("mqttReading of a topic not matching the filter did not fail")
+	}
+}
+
+//testBroker is just enough of an MQTT 3.1.1 broker for a single client:
+//it accepts the connection, acknowledges subscriptions and publishes QoS 1
+//messages to the client
+type testBroker struct {
+	listener   net.Listener
+	subscribed chan string
+	acked      chan uint16
+	mu         sync.Mutex
+	conn       net.Conn
+}
+
+func newTestBroker(t *testing.T) *testBroker {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	broker := &testBroker{listener: listener, subscribed: make(chan string, 10), acked: make(chan uint16, 10)}
+	go broker.serve()
+	return broker
+}
+
+func (b *testBroker) serve() {
+	conn, err := b.listener.Accept()
+	if err != nil {
+		return
+	}
+	b.mu.Lock()
+	b.conn = conn
+	b.mu.Unlock()
+	defer conn.Close()
+
+	r := bufio.NewReader(conn)
+	for {
+		packetType, body, err := readMqttPacket(r)
+		if err != nil {
+			return
+		}
+		switch packetType {
+		case 1: //CONNECT
+			b.write(0x20, []byte{0, 0})
+		case 8: //SUBSCRIBE
+			granted := []byte{body[0], body[1]}
+			for rest := body[2:]; len(rest) > 2; {
+				n := int(binary.BigEndian.Uint16(rest))
+				b.subscribed <- string(rest[2 : 2+n])
+				granted = append(granted, rest[2+n])
+				rest = rest[3+n:]
+			}
+			b.write(0x90, granted)
+		case 4: //PUBACK
+			b.acked <- binary.BigEndian.Uint16(body)
+		case 12: //PINGREQ
+			b.write(0xd0, nil)
+		case 14: //DISCONNECT
+			return
+		}
+	}
+}
+
+func readMqttPacket(r *bufio.Reader) (byte, []byte, error) {
+	header, err := r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	length, multiplier := 0, 1
+	for {
+		c, err := r.ReadByte()
+		if err != nil {
+			return 0, nil, err
+		}
+		length += int(c&127) * multiplier
+		if c&128 == 0 {
+			break
+		}
+		multiplier *= 128
+	}
+	body := make([]byte, length)
+	_, err = io.ReadFull(r, body)
+	return header >> 4, body, err
+}
+
+func (b *testBroker) write(header byte, body []byte) {
+	packet := []byte{header}
+	length := len(body)
+	for {
+		c := byte(length % 128)
+		length /= 128
+		if length > 0 {
+			c |= 128
+		}
+		packet = append(packet, c)
+		if length == 0 {
+			break
+		}
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.conn.Write(append(packet, body...))
+}
+
+func (b *testBroker) publish(topic string, id uint16, payload string) {
+	body := make([]byte, 2, 4+len(topic)+len(payload))
+	binary.BigEndian.PutUint16(body, uint16(len(topic)))
+	body = append(body, topic...)
+	body = append(body, byte(id>>8), byte(id))
+	b.write(0x32, append(body, payload...))
+}
+
+func TestMqttSubscriptions(t *testing.T) {
+	broker := newTestBroker(t)
+	defer broker.listener.Close()
+
+	uploads := make(chan map[string]interface{}, 1)
+	upload := mqttUpload
+	defer func() { mqttUpload = upload }()
+	mqttUpload = func(data map[string]interface{}) (string, error) {
+		uploads <- data
+		return uploadChip(data), nil
+	}
+
+	subscriptions := []MqttSubscription{{Topic: "home/+/+", Chip: "mqtt_{1}", Field: "{2}"}}
+	opts := mqtt.NewClientOptions().
+		AddBroker("tcp://" + broker.listener.Addr().String()).
+		SetClientID("goSensor-test").
+		SetOnConnectHandler(func(client mqtt.Client) {
+			mqttSubscribe(client, subscriptions)
+		})
+	client := mqtt.NewClient(opts)
+	if token := client.Connect(); token.Wait() && token.Error() != nil {
+		t.Fatal(token.Error())
+	}
+	defer client.Disconnect(100)
+
+	select {
+	case topic := <-broker.subscribed:
+		if topic != "home/+/+" {
+			t.Fatalf("subscribed to %q", topic)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no subscription")
+	}
+
+	broker.publish("home/kitchen/temp", 7, "21.5")
+	select {
+	case data := <-uploads:
+		if want := map[string]interface{}{"chip": "mqtt_kitchen", "temp": 21.5}; !reflect.DeepEqual(data, want) {
+			t.Errorf("uploaded %v, want %v", data, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("nothing uploaded")
+	}
+	select {
+	case id := <-broker.acked:
+		if id != 7 {
+			t.Fatalf("acknowledged message %d, want 7", id)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("message not acknowledged")
+	}
+
+	metrics.Lock()
+	defer metrics.Unlock()
+	if count := metrics.values["gosensor_uploads_total"][`chip="mqtt_kitchen",status="ok"`]; count != 1 {
+		t.Errorf("%v ok uploads counted for mqtt_kitchen, want 1", count)
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const RedisAlertDeliveryPrefix = "go_sensor_alert_delivery_"
+const AlertDeliveryLogSize = 100
+
+//errQueued is returned by notifiers that deliver later, they log the
+//delivery themselves
+var errQueued = errors.New("queued")
+
+//notifier is a notification channel for alert transitions
+type notifier interface {
+	Name() string
+	Notify(transition AlertTransition) error
+	Retries() int
+}
+
+//AlertDelivery is one entry of the delivery log of an alert
+type AlertDelivery struct {
+	Transition string  `json:"transition"` //id of the AlertTransition
+	Channel    string  `json:"channel"`
+	Status     string  `json:"status"` //firing or resolved
+	Time       float64 `json:"time"`
+	Attempts   int     `json:"attempts"`
+	Ok         bool    `json:"ok"`
+	Error      string  `json:"error,omitempty"`
+}
+
+//Status is firing or resolved
+func (t AlertTransition) Status() string {
+	if t.To == AlertFiring {
+		return "firing"
+	}
+	return "resolved"
+}
+
+func notifiers() []notifier {
+	var list []notifier
+	for _, conf := range Config().Webhooks {
+		list = append(list, webhookNotifier{conf})
+	}
+	if Config().Email.Host != "" {
+		list = append(list, emailNotifier{Config().Email})
+	}
+	return list
+}
+
+//notifyAlert hands a transition to every notification channel, each one is
+//delivered in the background and retried with backoff
+func notifyAlert(transition AlertTransition) {
+	fmt.Println("alert", transition.Rule.Name, transition.Rule.Severity, transition.From, "=>", transition.To, transition.Value)
+
+	reason := alertSuppressed(transition)
+	if transition.To != AlertFiring {
+		Redis().HDel(RedisAckKey, transition.Rule.Name)
+	}
+	if reason != "" {
+		logDelivery(transition.Rule.Name, AlertDelivery{
+			Transition: transition.Id,
+			Channel:    "suppressed",
+			Status:     transition.Status(),
+			Time:       float64(time.Now().Unix()),
+			Error:      reason,
+		})
+		return
+	}
+
+	for _, n := range notifiers() {
+		go deliver(n, transition)
+	}
+}
+
+func deliver(n notifier, transition AlertTransition) {
+	delivery := AlertDelivery{Transition: transition.Id, Channel: n.Name(), Status: transition.Status()}
+	backoff := time.Second
+	for {
+		delivery.Attempts++
+		err := n.Notify(transition)
+		if err == errQueued {
+			return
+		}
+		if err == nil {
+			delivery.Ok = true
+			delivery.Error = ""
+			break
+		}
+		delivery.Error = err.Error()
+		fmt.Println("notify", n.Name(), transition.Rule.Name, err)
+		if delivery.Attempts > n.Retries() {
+			break
+		}
+		time.Sleep(backoff)
+		if backoff < 5*time.Minute {
+			backoff *= 2
+		}
+	}
+
+	delivery.Time = float64(time.Now().Unix())
+	logDelivery(transition.Rule.Name, delivery)
+}
+
+func logDelivery(rule string, delivery AlertDelivery) {
+	byteStr, err := json.Marshal(delivery)
+	if err != nil {
+		return
+	}
+	Redis().RPush(RedisAlertDeliveryPrefix+rule, string(byteStr))
+	Redis().LTrim(RedisAlertDeliveryPrefix+rule, -AlertDeliveryLogSize, -1)
+}
+
+//alertDeliveries returns the delivery log of an alert, oldest first
+func alertDeliveries(rule string) []AlertDelivery {
+	list, _ := Redis().LRange(RedisAlertDeliveryPrefix+rule, 0, -1).Result()
+	deliveries := make([]AlertDelivery, 0, len(list))
+	for _, str := range list {
+		var delivery AlertDelivery
+		if json.Unmarshal([]byte(str), &delivery) == nil {
+			deliveries = append(deliveries, delivery)
+		}
+	}
+	return deliveries
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+)
+
+type fieldError struct {
+	Field string      `json:"field"`
+	Error string      `json:"error"`
+	Value interface{} `json:"value,omitempty"`
+	Unit  string      `json:"unit,omitempty"`
+	Min   *float64    `json:"min,omitempty"`
+	Max   *float64    `json:"max,omitempty"`
+}
+
+//uploadError is returned for rejected uploads and written to the client as JSON
+type uploadError struct {
+	Status  int          `json:"-"`
+	Message string       `json:"error"`
+	Chip    string       `json:"chip,omitempty"`
+	Fields  []fieldError `json:"fields,omitempty"`
+}
+
+func (e *uploadError) Error() string {
+	return e.Message
+}
+
+func writeUploadError(w http.ResponseWriter, err error) {
+	e, ok := err.(*uploadError)
+	if !ok {
+		e = &uploadError{Status: 500, Message: err.Error()}
+	}
+	byteStr, _ := json.Marshal(e)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(e.Status)
+	w.Write(byteStr)
+}
+
+//validateSchema checks a reading against the schema configured for its chip
+func validateSchema(chip string, data map[string]interface{}) error {
+	schema, ok := Config().Schemas[chip]
+	if !ok {
+		return nil
+	}
+
+	fields := make([]string, 0, len(schema))
+	for field := range schema {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	var errs []fieldError
+	for _, field := range fields {
+		fieldSchema := schema[field]
+		value, ok := data[field]
+		if !ok {
+			if fieldSchema.Required {
+				errs = append(errs, fieldError{Field: field, Error: "required"})
+			}
+			continue
+		}
+
+		switch fieldSchema.Type {
+		case "number":
+			number, ok := value.(float64)
+			if !ok {
+				errs = append(errs, fieldError{Field: field, Error: "expected number", Value: value})
+				continue
+			}
+			if (fieldSchema.Min != nil && number < *fieldSchema.Min) || (fieldSchema.Max != nil && number > *fieldSchema.Max) {
+				errs = append(errs, fieldError{
+					Field: field,
+					Error: "out of range",
+					Value: value,
+					Unit:  fieldSchema.Unit,
+					Min:   fieldSchema.Min,
+					Max:   fieldSchema.Max,
+				})
+			}
+		case "string":
+			if _, ok := value.(string); !ok {
+				errs = append(errs, fieldError{Field: field, Error: "expected string", Value: value})
+			}
+		case "bool":
+			if _, ok := value.(bool); !ok {
+				errs = append(errs, fieldError{Field: field, Error: "expected bool", Value: value})
+			}
+		}
+	}
+
+	if len(errs) > 0 {
+		return &uploadError{Status: 422, Message: "schema validation failed", Chip: chip, Fields: errs}
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestValidateSchema(t *testing.T) {
+	min, max := -40.0, 85.0
+	conf := Config()
+	saved := conf.Schemas
+	defer func() { conf.Schemas = saved }()
+	conf.Schemas = map[string]map[string]FieldSchema{
+		"room": {
+			"temp":  {Type: "number", Required: true, Unit: "°C", Min: &min, Max: &max},
+			"label": {Type: "string"},
+			"open":  {Type: "bool"},
+		},
+	}
+
+	tests := []struct {
+		name   string
+		chip   string
+		data   map[string]interface{}
+		fields []fieldError
+	}{
+		{"valid", "room", map[string]interface{}{"temp": 21.5, "label": "kitchen", "open": false, "extra": 1.0}, nil},
+		{"no schema", "other", map[string]interface{}{"temp": "hot"}, nil},
+		{"required", "room", map[string]interface{}{"label": "kitchen"}, []fieldError{{Field: "temp", Error: "required"}}},
+		{
+			"types",
+			"room",
+			map[string]interface{}{"temp": "21", "label": 1.0, "open": "yes"},
+			[]fieldError{
+				{Field: "label", Error: "expected string", Value: 1.0},
+				{Field: "open", Error: "expected bool", Value: "yes"},
+				{Field: "temp", Error: "expected number", Value: "21"},
+			},
+		},
+		{
+			"range",
+			"room",
+			map[string]interface{}{"temp": 90.0},
+			[]fieldError{{Field: "temp", Error: "out of range", Value: 90.0, Unit: "°C", Min: &min, Max: &max}},
+		},
+	}
+	for _, test := range tests {
+		err := validateSchema(test.chip, test.data)
+		if test.fields == nil {
+			if err != nil {
+				t.Errorf("%s: validateSchema = %v", test.name, err)
+			}
+			continue
+		}
+		e, ok := err.(*uploadError)
+		if !ok || e.Status != 422 || e.Chip != test.chip || !reflect.DeepEqual(e.Fields, test.fields) {
+			t.Errorf("%s: validateSchema = %+v, want 422 with %+v", test.name, err, test.fields)
+		}
+	}
+}
+
+func TestWriteUploadError(t *testing.T) {
+	max := 85.0
+	w := httptest.NewRecorder()
+	writeUploadError(w, &uploadError{
+		Status:  422,
+		Message: "schema validation failed",
+		Chip:    "room",
+		Fields:  []fieldError{{Field: "temp", Error: "out of range", Value: 90.0, Unit: "°C", Max: &max}},
+	})
+	if w.Code != 422 || w.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("status %d, content type %q", w.Code, w.Header().Get("Content-Type"))
+	}
+	var body map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &body)
+	want := map[string]interface{}{
+		"error":  "schema validation failed",
+		"chip":   "room",
+		"fields": []interface{}{map[string]interface{}{"field": "temp", "error": "out of range", "value": 90.0, "unit": "°C", "max": 85.0}},
+	}
+	if !reflect.DeepEqual(body, want) {
+		t.Errorf("body = %s", w.Body)
+	}
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"net/url"
+	"strconv"
+)
+
+const (
+	GapNull     = "null"
+	GapPrevious = "previous"
+	GapLinear   = "linear"
+
+	SmoothMovingAverage = "moving_average"
+	SmoothEwma          = "ewma"
+)
+
+//seriesOptions controls how the readings of a series are put on the
+//PointInterval grid by sensorJson()
+type seriesOptions struct {
+	Gap    string //null, previous or linear
+	MaxGap int64  //seconds of missing points filled at most, 0 for any gap
+	Smooth string //moving_average, ewma or empty
+	Window int    //points of the moving average
+	Alpha  float64
+}
+
+func defaultSeriesOptions() seriesOptions {
+	return seriesOptions{Gap: GapPrevious, MaxGap: 2 * PointInterval, Window: 3, Alpha: 0.3}
+}
+
+//parseSeriesOptions reads ?gap=&max_gap=&smooth=&window=&alpha=, custom is
+//false if none of them is given
+func parseSeriesOptions(query url.Values) (opts seriesOptions, custom bool, err error) {
+	opts = defaultSeriesOptions()
+	for _, name := range []string{"gap", "max_gap", "smooth", "window", "alpha"} {
+		if _, ok := query[name]; ok {
+			custom = true
+		}
+	}
+
+	if gap := query.Get("gap"); gap != "" {
+		if gap != GapNull && gap != GapPrevious && gap != GapLinear {
+			return opts, custom, errors.New("gap must be null, previous or linear")
+		}
+		opts.Gap = gap
+	}
+	if maxGap := query.Get("max_gap"); maxGap != "" {
+		if opts.MaxGap, err = strconv.ParseInt(maxGap, 10, 64); err != nil || opts.MaxGap < 0 {
+			return opts, custom, errors.New("max_gap must be a number of seconds")
+		}
+	}
+	if smooth := query.Get("smooth"); smooth != "" {
+		if smooth != SmoothMovingAverage && smooth != SmoothEwma {
+			return opts, custom, errors.New("smooth must be moving_average or ewma")
+		}
+		opts.Smooth = smooth
+	}
+	if window := query.Get("window"); window != "" {
+		if opts.Window, err = strconv.Atoi(window); err != nil || opts.Window < 1 {
+			return opts, custom, errors.New("window must be a positive number of points")
+		}
+	}
+	if alpha := query.Get("alpha"); alpha != "" {
+		if opts.Alpha, err = strconv.ParseFloat(alpha, 64); err != nil || opts.Alpha <= 0 || opts.Alpha > 1 {
+			return opts, custom, errors.New("alpha must be in (0, 1]")
+		}
+	}
+	return opts, custom, nil
+}
+
+//gridTime is the start of the PointInterval a reading falls into
+func gridTime(addTime float64) int64 {
+	t := int64(addTime)
+	return t - t%PointInterval
+}
+
+//gridValues lays out the points (grid time => value) from the first to the
+//last one, filling the gaps and smoothing as opts says. NaN points are null
+//and never filled.
+func gridValues(points map[int64]float64, opts seriesOptions) (int64, []interface{}) {
+	var start, end int64
+	for t := range points {
+		if start == 0 || t < start {
+			start = t
+		}
+		if t > end {
+			end = t
+		}
+	}
+	if len(points) == 0 {
+		return 0, []interface{}{}
+	}
+
+	values := make([]interface{}, 0, (end-start)/PointInterval+1)
+	var previous, next int64
+	for t := start; t <= end; t += PointInterval {
+		value, ok := points[t]
+		if ok {
+			previous = t
+			if math.IsNaN(value) {
+				values = append(values, nil)
+			} else {
+				values = append(values, value)
+			}
+			continue
+		}
+
+		//the next known point, there always is one as end is known
+		if next < t {
+			for next = t + PointInterval; next < end; next += PointInterval {
+				if _, ok := points[next]; ok {
+					break
+				}
+			}
+		}
+		//gaps next to a null point stay null
+		bounded := math.IsNaN(points[previous]) || math.IsNaN(points[next])
+		missing := (next - previous) - PointInterval
+		if opts.Gap == GapNull || bounded || (opts.MaxGap > 0 && missing > opts.MaxGap) {
+			values = append(values, nil)
+			continue
+		}
+		if opts.Gap == GapLinear {
+			ratio := float64(t-previous) / float64(next-previous)
+			values = append(values, points[previous]+(points[next]-points[previous])*ratio)
+			continue
+		}
+		values = append(values, points[previous])
+	}
+
+	switch opts.Smooth {
+	case SmoothMovingAverage:
+		values = movingAverage(values, opts.Window)
+	case SmoothEwma:
+		values = ewma(values, opts.Alpha)
+	}
+	return start, values
+}
+
+//movingAverage averages the known values of the trailing window, gaps stay
+//null
+func movingAverage(values []interface{}, window int) []interface{} {
+	smoothed := make([]interface{}, len(values))
+	for i, value := range values {
+		if value == nil {
+			continue
+		}
+		sum, count := 0.0, 0
+		for j := i; j >= 0 && j > i-window; j-- {
+			if v, ok := values[j].(float64); ok {
+				sum += v
+				count++
+			}
+		}
+		smoothed[i] = sum / float64(count)
+	}
+	return smoothed
+}
+
+//ewma smooths exponentially, starting over after every gap
+func ewma(values []interface{}, alpha float64) []interface{} {
+	smoothed := make([]interface{}, len(values))
+	var last interface{}
+	for i, value := range values {
+		v, ok := value.(float64)
+		if !ok {
+			last = nil
+			continue
+		}
+		if previous, ok := last.(float64); ok {
+			v = alpha*v + (1-alpha)*previous
+		}
+		smoothed[i] = v
+		last = v
+	}
+	return smoothed
+}
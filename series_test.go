@@ -0,0 +1,78 @@
+package main
+
+import (
+	"math"
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func TestGridValues(t *testing.T) {
+	const start = 10 * PointInterval
+	gap := map[int64]float64{start: 1, start + 3*PointInterval: 4}
+	nullGap := map[int64]float64{start: 1, start + PointInterval: math.NaN(), start + 4*PointInterval: 4}
+
+	options := func(gap string, maxGap int64, smooth string) seriesOptions {
+		opts := defaultSeriesOptions()
+		opts.Gap, opts.MaxGap, opts.Smooth = gap, maxGap, smooth
+		return opts
+	}
+	tests := []struct {
+		name   string
+		points map[int64]float64
+		opts   seriesOptions
+		want   []interface{}
+	}{
+		{"previous", gap, defaultSeriesOptions(), []interface{}{1.0, 1.0, 1.0, 4.0}},
+		{"linear", gap, options(GapLinear, 0, ""), []interface{}{1.0, 2.0, 3.0, 4.0}},
+		{"null", gap, options(GapNull, 0, ""), []interface{}{1.0, nil, nil, 4.0}},
+		{"max_gap", gap, options(GapLinear, PointInterval, ""), []interface{}{1.0, nil, nil, 4.0}},
+		{"flagged", nullGap, options(GapLinear, 0, ""), []interface{}{1.0, nil, nil, nil, 4.0}},
+		{"moving_average", gap, options(GapLinear, 0, SmoothMovingAverage), []interface{}{1.0, 1.5, 2.0, 3.0}},
+		{"ewma", gap, options(GapNull, 0, SmoothEwma), []interface{}{1.0, nil, nil, 4.0}},
+	}
+	for _, test := range tests {
+		first, got := gridValues(test.points, test.opts)
+		if first != start {
+			t.Errorf("%s: start = %d, want %d", test.name, first, start)
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: gridValues = %v, want %v", test.name, got, test.want)
+		}
+	}
+
+	if first, got := gridValues(map[int64]float64{}, defaultSeriesOptions()); first != 0 || len(got) != 0 {
+		t.Errorf("gridValues of no points = %d, %v", first, got)
+	}
+}
+
+func TestSmoothing(t *testing.T) {
+	values := []interface{}{1.0, 2.0, nil, 4.0, 6.0}
+	if got, want := movingAverage(values, 2), []interface{}{1.0, 1.5, nil, 4.0, 5.0}; !reflect.DeepEqual(got, want) {
+		t.Errorf("movingAverage = %v, want %v", got, want)
+	}
+	if got, want := ewma(values, 0.5), []interface{}{1.0, 1.5, nil, 4.0, 5.0}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ewma = %v, want %v", got, want)
+	}
+}
+
+func TestParseSeriesOptions(t *testing.T) {
+	opts, custom, err := parseSeriesOptions(url.Values{})
+	if err != nil || custom || opts != defaultSeriesOptions() {
+		t.Errorf("no options = %+v, %v, %v", opts, custom, err)
+	}
+
+	query, _ := url.ParseQuery("gap=linear&max_gap=0&smooth=ewma&alpha=0.5")
+	opts, custom, err = parseSeriesOptions(query)
+	want := seriesOptions{Gap: GapLinear, MaxGap: 0, Smooth: SmoothEwma, Window: 3, Alpha: 0.5}
+	if err != nil || !custom || opts != want {
+		t.Errorf("parseSeriesOptions(%v) = %+v, %v, %v", query, opts, custom, err)
+	}
+
+	for _, raw := range []string{"gap=zero", "max_gap=-1", "smooth=median", "window=0", "alpha=1.5"} {
+		query, _ := url.ParseQuery(raw)
+		if _, _, err := parseSeriesOptions(query); err == nil {
+			t.Errorf("parseSeriesOptions(%s) did not fail", raw)
+		}
+	}
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"time"
+)
+
+const RedisSilenceKey = "go_sensor_alert_silences"
+const RedisMaintenanceKey = "go_sensor_alert_maintenance"
+const RedisAckKey = "go_sensor_alert_acks"
+
+//AlertMatcher matches alerts by rule name, series and severity, empty
+//fields match everything
+type AlertMatcher struct {
+	Rule     string `json:"rule"`
+	Series   string `json:"series"`
+	Severity string `json:"severity"`
+}
+
+func (m AlertMatcher) Match(rule AlertRule) bool {
+	return (m.Rule == "" || m.Rule == rule.Name) &&
+		(m.Series == "" || m.Series == rule.Series || m.Series == rule.OtherSeries) &&
+		(m.Severity == "" || m.Severity == rule.Severity)
+}
+
+//Silence mutes matching alerts between StartsAt and EndsAt
+type Silence struct {
+	Id        string       `json:"id"`
+	Matcher   AlertMatcher `json:"matcher"`
+	StartsAt  int64        `json:"starts_at"`
+	EndsAt    int64        `json:"ends_at"`
+	Comment   string       `json:"comment"`
+	Author    string       `json:"author"`
+	CreatedAt int64        `json:"created_at"`
+}
+
+func (s Silence) Active(now time.Time) bool {
+	return now.Unix() >= s.StartsAt && now.Unix() < s.EndsAt
+}
+
+//MaintenanceWindow mutes matching alerts every week on Weekdays (0 is
+//Sunday) from Start (HH:MM, local time) for Duration minutes
+type MaintenanceWindow struct {
+	Id        string       `json:"id"`
+	Matcher   AlertMatcher `json:"matcher"`
+	Weekdays  []int        `json:"weekdays"`
+	Start     string       `json:"start"`
+	Duration  int          `json:"duration"`
+	Comment   string       `json:"comment"`
+	Author    string       `json:"author"`
+	CreatedAt int64        `json:"created_at"`
+}
+
+func (m MaintenanceWindow) Active(now time.Time) bool {
+	start, err := time.ParseInLocation("15:04", m.Start, now.Location())
+	if err != nil {
+		return false
+	}
+	//windows may run past midnight, so check the ones started today and in the last week
+	for days := 0; days <= 7; days++ {
+		day := now.AddDate(0, 0, -days)
+		from := time.Date(day.Year(), day.Month(), day.Day(), start.Hour(), start.Minute(), 0, 0, now.Location())
+		to := from.Add(time.Duration(m.Duration) * time.Minute)
+		if now.Before(from) || !now.Before(to) {
+			continue
+		}
+		for _, weekday := range m.Weekdays {
+			if time.Weekday(weekday) == from.Weekday() {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+//AlertAck acknowledges a firing alert, it is cleared when the alert resolves
+type AlertAck struct {
+	Rule    string `json:"rule"`
+	Author  string `json:"author"`
+	Comment string `json:"comment"`
+	Time    int64  `json:"time"`
+}
+
+func newId() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func silences() []Silence {
+	all, _ := Redis().HGetAll(RedisSilenceKey).Result()
+	list := make([]Silence, 0, len(all))
+	for _, str := range all {
+		var silence Silence
+		if json.Unmarshal([]byte(str), &silence) == nil {
+			list = append(list, silence)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].StartsAt < list[j].StartsAt })
+	return list
+}
+
+func maintenanceWindows() []MaintenanceWindow {
+	all, _ := Redis().HGetAll(RedisMaintenanceKey).Result()
+	list := make([]MaintenanceWindow, 0, len(all))
+	for _, str := range all {
+		var window MaintenanceWindow
+		if json.Unmarshal([]byte(str), &window) == nil {
+			list = append(list, window)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].CreatedAt < list[j].CreatedAt })
+	return list
+}
+
+func alertAck(rule string) (AlertAck, bool) {
+	var ack AlertAck
+	str, err := Redis().HGet(RedisAckKey, rule).Result()
+	if err != nil {
+		return ack, false
+	}
+	return ack, json.Unmarshal([]byte(str), &ack) == nil
+}
+
+//alertSuppressed returns why the notifications of a transition are
+//suppressed, or an empty string if they are not
+func alertSuppressed(transition AlertTransition) string {
+	now := time.Now()
+	for _, silence := range silences() {
+		if silence.Active(now) && silence.Matcher.Match(transition.Rule) {
+			return "silenced by " + silence.Id
+		}
+	}
+	for _, window := range maintenanceWindows() {
+		if window.Active(now) && window.Matcher.Match(transition.Rule) {
+			return "maintenance window " + window.Id
+		}
+	}
+	if transition.To == AlertFiring {
+		if ack, ok := alertAck(transition.Rule.Name); ok {
+			return "acknowledged by " + ack.Author
+		}
+	}
+	return ""
+}
+
+func writeJson(w http.ResponseWriter, status int, v interface{}) {
+	byteStr, err := json.Marshal(v)
+	if err != nil {
+		status = 500
+		byteStr, _ = json.Marshal(map[string]string{"error": err.Error()})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(byteStr)
+}
+
+func writeJsonError(w http.ResponseWriter, status int, err error) {
+	writeJson(w, status, map[string]string{"error": err.Error()})
+}
+
+func readJson(r *http.Request, v interface{}) error {
+	b, err := ioutil.ReadAll(r.Body)
+	defer r.Body.Close()
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+//silencesHandler: GET lists, POST creates and DELETE ?id= expires silences
+func silencesHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		writeJson(w, 200, silences())
+	case "POST":
+		var silence Silence
+		if err := readJson(r, &silence); err != nil {
+			writeJsonError(w, 400, err)
+			return
+		}
+		if silence.StartsAt == 0 {
+			silence.StartsAt = time.Now().Unix()
+		}
+		if silence.EndsAt <= silence.StartsAt {
+			writeJsonError(w, 422, errors.New("ends_at must be after starts_at"))
+			return
+		}
+		if silence.Author == "" {
+			writeJsonError(w, 422, errors.New("author is required"))
+			return
+		}
+		silence.Id = newId()
+		silence.CreatedAt = time.Now().Unix()
+		byteStr, _ := json.Marshal(silence)
+		Redis().HSet(RedisSilenceKey, silence.Id, string(byteStr))
+		writeJson(w, 201, silence)
+	case "DELETE":
+		Redis().HDel(RedisSilenceKey, r.URL.Query().Get("id"))
+		w.WriteHeader(204)
+	default:
+		w.WriteHeader(405)
+	}
+}
+
+//maintenanceHandler: GET lists, POST creates and DELETE ?id= removes maintenance windows
+func maintenanceHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		writeJson(w, 200, maintenanceWindows())
+	case "POST":
+		var window MaintenanceWindow
+		if err := readJson(r, &window); err != nil {
+			writeJsonError(w, 400, err)
+			return
+		}
+		if _, err := time.Parse("15:04", window.Start); err != nil {
+			writeJsonError(w, 422, errors.New("start must be HH:MM"))
+			return
+		}
+		if window.Duration <= 0 || len(window.Weekdays) == 0 {
+			writeJsonError(w, 422, errors.New("duration and weekdays are required"))
+			return
+		}
+		if window.Author == "" {
+			writeJsonError(w, 422, errors.New("author is required"))
+			return
+		}
+		window.Id = newId()
+		window.CreatedAt = time.Now().Unix()
+		byteStr, _ := json.Marshal(window)
+		Redis().HSet(RedisMaintenanceKey, window.Id, string(byteStr))
+		writeJson(w, 201, window)
+	case "DELETE":
+		Redis().HDel(RedisMaintenanceKey, r.URL.Query().Get("id"))
+		w.WriteHeader(204)
+	default:
+		w.WriteHeader(405)
+	}
+}
+
+//ackHandler: POST acknowledges a firing alert, DELETE ?rule= withdraws it
+func ackHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "POST":
+		var ack AlertAck
+		if err := readJson(r, &ack); err != nil {
+			writeJsonError(w, 400, err)
+			return
+		}
+		if ack.Rule == "" || ack.Author == "" {
+			writeJsonError(w, 422, errors.New("rule and author are required"))
+			return
+		}
+		if state, ok := alertState(ack.Rule); !ok || state.State != AlertFiring {
+			writeJsonError(w, 422, errors.New("alert "+ack.Rule+" is not firing"))
+			return
+		}
+		ack.Time = time.Now().Unix()
+		byteStr, _ := json.Marshal(ack)
+		Redis().HSet(RedisAckKey, ack.Rule, string(byteStr))
+		writeJson(w, 201, ack)
+	case "DELETE":
+		Redis().HDel(RedisAckKey, r.URL.Query().Get("rule"))
+		w.WriteHeader(204)
+	default:
+		w.WriteHeader(405)
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMaintenanceWindowActive(t *testing.T) {
+	//2024-01-01 is a Monday
+	at := func(day int, hour int, minute int) time.Time {
+		return time.Date(2024, 1, day, hour, minute, 0, 0, time.UTC)
+	}
+	nightly := MaintenanceWindow{Weekdays: []int{1}, Start: "23:00", Duration: 120}
+	weekend := MaintenanceWindow{Weekdays: []int{6}, Start: "22:00", Duration: 3 * 24 * 60}
+
+	tests := []struct {
+		name   string
+		window MaintenanceWindow
+		now    time.Time
+		want   bool
+	}{
+		{"before the start", nightly, at(1, 22, 59), false},
+		{"at the start", nightly, at(1, 23, 0), true},
+		{"past midnight", nightly, at(2, 0, 30), true},
+		{"at the end", nightly, at(2, 1, 0), false},
+		{"other weekday", nightly, at(2, 23, 30), false},
+		{"a week later", nightly, at(8, 23, 30), true},
+		{"days later", weekend, at(9, 21, 0), true},
+		{"after days", weekend, at(9, 22, 0), false},
+		{"invalid start", MaintenanceWindow{Weekdays: []int{1}, Start: "23h", Duration: 60}, at(1, 23, 30), false},
+		{"no weekdays", MaintenanceWindow{Start: "23:00", Duration: 60}, at(1, 23, 30), false},
+	}
+	for _, test := range tests {
+		if got := test.window.Active(test.now); got != test.want {
+			t.Errorf("%s: Active(%s) = %v, want %v", test.name, test.now.Format("Mon 15:04"), got, test.want)
+		}
+	}
+}
+
+func TestSilenceActive(t *testing.T) {
+	silence := Silence{StartsAt: 1000, EndsAt: 2000}
+	for now, want := range map[int64]bool{999: false, 1000: true, 1999: true, 2000: false} {
+		if got := silence.Active(time.Unix(now, 0)); got != want {
+			t.Errorf("Active(%d) = %v, want %v", now, got, want)
+		}
+	}
+}
+
+func TestAlertMatcher(t *testing.T) {
+	rule := AlertRule{Name: "hot", Series: "room", OtherSeries: "outside", Severity: "critical"}
+	tests := []struct {
+		matcher AlertMatcher
+		want    bool
+	}{
+		{AlertMatcher{}, true},
+		{AlertMatcher{Rule: "hot"}, true},
+		{AlertMatcher{Rule: "cold"}, false},
+		{AlertMatcher{Series: "outside"}, true},
+		{AlertMatcher{Series: "room", Severity: "warning"}, false},
+		{AlertMatcher{Rule: "hot", Series: "room", Severity: "critical"}, true},
+	}
+	for _, test := range tests {
+		if got := test.matcher.Match(rule); got != test.want {
+			t.Errorf("%+v.Match = %v, want %v", test.matcher, got, test.want)
+		}
+	}
+}
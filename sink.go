@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+//Sink is a destination of the stored readings. Write and Flush are only
+//ever called from the sink's own goroutine.
+type Sink interface {
+	Write(name string, data map[string]interface{}) error
+}
+
+//flusher is implemented by sinks that buffer writes, Flush is called about
+//once a second while the queue is idle
+type flusher interface {
+	Flush() error
+}
+
+type sinkReading struct {
+	name string
+	data map[string]interface{}
+}
+
+type SinkStatus struct {
+	Name            string `json:"name"`
+	Status          string `json:"status"` //ok, failing or idle
+	Queued          int    `json:"queued"`
+	Written         int64  `json:"written"`
+	Failed          int64  `json:"failed"`
+	Dropped         int64  `json:"dropped"`
+	LastError       string `json:"last_error,omitempty"`
+	LastErrorTime   int64  `json:"last_error_time,omitempty"`
+	LastSuccessTime int64  `json:"last_success_time,omitempty"`
+}
+
+//sinkRunner feeds a sink from its own bounded queue so one slow sink cannot
+//block collection, uploads or the other sinks.
+type sinkRunner struct {
+	name   string
+	sink   Sink
+	conf   SinkConfig
+	queue  chan sinkReading
+	mu     sync.Mutex
+	status SinkStatus
+}
+
+var sinks []*sinkRunner
+
+func newSinkRunner(name string, sink Sink) *sinkRunner {
+	conf := Config().sinkConfig(name)
+	return &sinkRunner{
+		name:   name,
+		sink:   sink,
+		conf:   conf,
+		queue:  make(chan sinkReading, conf.QueueSize),
+		status: SinkStatus{Name: name, Status: "idle"},
+	}
+}
+
+//startSinks starts every configured sink, the redis storage is written by
+//saveData itself before the reading is fanned out
+func startSinks() {
+	conf := Config()
+	if conf.Mqtt.Broker != "" && conf.Mqtt.Publish.Enabled {
+		sinks = append(sinks, newSinkRunner("mqtt", &mqttSink{latest: map[string]float64{}}))
+	}
+	if conf.InfluxSink.URL != "" {
+		sinks = append(sinks, newSinkRunner("influx", &influxSink{}))
+	}
+	if conf.Graphite.Address != "" {
+		sinks = append(sinks, newSinkRunner("graphite", &graphiteSink{}))
+	}
+	if conf.sinkConfig("webhook").URL != "" {
+		sinks = append(sinks, newSinkRunner("webhook", webhookSink{}))
+	}
+	if conf.sinkConfig("file").Path != "" {
+		sinks = append(sinks, newSinkRunner("file", fileSink{}))
+	}
+
+	for _, runner := range sinks {
+		go runner.run()
+	}
+}
+
+//fanOut queues a stored reading for every sink, the oldest queued reading
+//of a sink is dropped when its queue is full
+func fanOut(name string, data map[string]interface{}) {
+	for _, runner := range sinks {
+		reading := sinkReading{name, data}
+		select {
+		case runner.queue <- reading:
+			continue
+		default:
+		}
+
+		select {
+		case <-runner.queue:
+			runner.count(&runner.status.Dropped, 1)
+		default:
+		}
+		select {
+		case runner.queue <- reading:
+		default:
+			runner.count(&runner.status.Dropped, 1)
+		}
+	}
+}
+
+func (runner *sinkRunner) count(counter *int64, delta int64) {
+	runner.mu.Lock()
+	*counter += delta
+	runner.mu.Unlock()
+}
+
+func (runner *sinkRunner) run() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	flush, canFlush := runner.sink.(flusher)
+	for {
+		select {
+		case reading := <-runner.queue:
+			runner.write(reading)
+		case <-ticker.C:
+			if canFlush {
+				runner.result(flush.Flush())
+			}
+		}
+	}
+}
+
+//write retries a reading with exponential backoff, a negative Retries
+//retries until it succeeds
+func (runner *sinkRunner) write(reading sinkReading) {
+	backoff := time.Duration(runner.conf.RetryBackoff) * time.Second
+	for i := 0; ; i++ {
+		err := runner.sink.Write(reading.name, reading.data)
+		runner.result(err)
+		if err == nil {
+			runner.count(&runner.status.Written, 1)
+			return
+		}
+
+		fmt.Println("sink", runner.name, err)
+		if runner.conf.Retries >= 0 && i >= runner.conf.Retries {
+			runner.count(&runner.status.Failed, 1)
+			return
+		}
+		time.Sleep(backoff)
+		if backoff < time.Minute {
+			backoff *= 2
+		}
+	}
+}
+
+func (runner *sinkRunner) result(err error) {
+	runner.mu.Lock()
+	defer runner.mu.Unlock()
+	if err != nil {
+		runner.status.Status = "failing"
+		runner.status.LastError = err.Error()
+		runner.status.LastErrorTime = time.Now().Unix()
+		return
+	}
+	runner.status.Status = "ok"
+	runner.status.LastSuccessTime = time.Now().Unix()
+}
+
+func (runner *sinkRunner) Status() SinkStatus {
+	runner.mu.Lock()
+	defer runner.mu.Unlock()
+	status := runner.status
+	status.Queued = len(runner.queue)
+	return status
+}
+
+func sinksHandler(w http.ResponseWriter, r *http.Request) {
+	statuses := make([]SinkStatus, 0, len(sinks))
+	for _, runner := range sinks {
+		statuses = append(statuses, runner.Status())
+	}
+	byteStr, _ := json.Marshal(statuses)
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(byteStr)
+}
+
+//webhookSink POSTs every reading as JSON
+type webhookSink struct{}
+
+func (webhookSink) Write(name string, data map[string]interface{}) error {
+	byteStr, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	client := http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Post(Config().sinkConfig("webhook").URL, "application/json", bytes.NewReader(byteStr))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return errors.New("webhook " + resp.Status)
+	}
+	return nil
+}
+
+//fileSink appends every reading to a NDJSON file
+type fileSink struct{}
+
+func (fileSink) Write(name string, data map[string]interface{}) error {
+	byteStr, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(Config().sinkConfig("file").Path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.Write(append(byteStr, '\n'))
+	return err
+}
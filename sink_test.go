@@ -0,0 +1,82 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestFanOutDropsOldest(t *testing.T) {
+	saved := sinks
+	defer func() { sinks = saved }()
+	small := &sinkRunner{name: "small", queue: make(chan sinkReading, 2)}
+	large := &sinkRunner{name: "large", queue: make(chan sinkReading, 10)}
+	sinks = []*sinkRunner{small, large}
+
+	for _, temp := range []float64{1, 2, 3, 4} {
+		fanOut("room", map[string]interface{}{"temp": temp})
+	}
+
+	tests := []struct {
+		runner  *sinkRunner
+		queued  []float64
+		dropped int64
+	}{
+		{small, []float64{3, 4}, 2},
+		{large, []float64{1, 2, 3, 4}, 0},
+	}
+	for _, test := range tests {
+		status := test.runner.Status()
+		if status.Queued != len(test.queued) || status.Dropped != test.dropped {
+			t.Errorf("%s: %d queued, %d dropped, want %d and %d",
+				test.runner.name, status.Queued, status.Dropped, len(test.queued), test.dropped)
+		}
+		for _, want := range test.queued {
+			if got := (<-test.runner.queue).data["temp"]; got != want {
+				t.Errorf("%s: dequeued %v, want %v", test.runner.name, got, want)
+			}
+		}
+	}
+}
+
+//failingSink fails as many writes as failures says before it succeeds
+type failingSink struct {
+	failures int
+	writes   int
+}
+
+func (sink *failingSink) Write(name string, data map[string]interface{}) error {
+	sink.writes++
+	if sink.writes <= sink.failures {
+		return errors.New("unavailable")
+	}
+	return nil
+}
+
+func TestSinkRunnerWrite(t *testing.T) {
+	tests := []struct {
+		failures int
+		retries  int
+		writes   int
+		written  int64
+		failed   int64
+		status   string
+	}{
+		{0, 0, 1, 1, 0, "ok"},
+		{2, 2, 3, 1, 0, "ok"},
+		{3, 1, 2, 0, 1, "failing"},
+		{5, -1, 6, 1, 0, "ok"},
+	}
+	for i, test := range tests {
+		sink := &failingSink{failures: test.failures}
+		runner := &sinkRunner{name: "test", sink: sink, conf: SinkConfig{Retries: test.retries}, status: SinkStatus{Status: "idle"}}
+		runner.write(sinkReading{"room", map[string]interface{}{"temp": 1.0}})
+
+		status := runner.Status()
+		if sink.writes != test.writes || status.Written != test.written || status.Failed != test.failed || status.Status != test.status {
+			t.Errorf("%d: %d writes, %+v", i, sink.writes, status)
+		}
+		if test.failures > 0 && status.LastError != "unavailable" {
+			t.Errorf("%d: last error %q", i, status.LastError)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+const RedisLastSeenKey = "go_sensor_last_seen"
+
+//staleRule is the alert rule behind the freshness check of a series, its
+//value is the age of the latest reading in seconds
+func staleRule(series string, conf StaleConfig) AlertRule {
+	return AlertRule{
+		Name:       "stale_" + series,
+		Series:     series,
+		Field:      "age",
+		Comparator: ">",
+		Threshold:  float64(conf.MaxAge),
+		Severity:   conf.Severity,
+	}
+}
+
+//lastSeen returns the add_time of the latest reading of a series, 0 if it
+//never reported. Series stored before the tracking started are seeded from
+//their latest stored reading.
+func lastSeen(series string) float64 {
+	str, err := Redis().HGet(RedisLastSeenKey, series).Result()
+	if err == nil {
+		addTime, _ := strconv.ParseFloat(str, 64)
+		return addTime
+	}
+	readings := latestReadings(RedisDataKeyPrefix+series, 1)
+	if len(readings) == 0 {
+		return 0
+	}
+	addTime := uploadAddTime(readings[0])
+	Redis().HSetNX(RedisLastSeenKey, series, strconv.FormatFloat(addTime, 'f', -1, 64))
+	return addTime
+}
+
+//touchSeries records the add_time of a stored reading and resolves the stale
+//alert of the series once a fresh reading comes in
+func touchSeries(name string, data map[string]interface{}) {
+	addTime := uploadAddTime(data)
+	if addTime > lastSeen(name) {
+		Redis().HSet(RedisLastSeenKey, name, strconv.FormatFloat(addTime, 'f', -1, 64))
+	}
+
+	if conf, ok := Config().Stale[name]; ok {
+		now := float64(time.Now().Unix())
+		evaluateAlertRule(staleRule(name, conf), now-lastSeen(name), now)
+	}
+}
+
+//checkStaleness fires the stale alert of every series that has not seen a
+//reading within its max age, it returns false if any series is stale
+func checkStaleness() bool {
+	fresh := true
+	now := float64(time.Now().Unix())
+	for series, conf := range Config().Stale {
+		seen := lastSeen(series)
+		//a series that never reported has nothing to go stale
+		if seen == 0 {
+			continue
+		}
+		age := now - seen
+		if age > float64(conf.MaxAge) {
+			fmt.Println(series+" 数据已过期", time.Duration(age)*time.Second)
+			fresh = false
+		}
+		evaluateAlertRule(staleRule(series, conf), age, now)
+	}
+	return fresh
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+//The readings of a series are kept in a sorted set scored by add_time, so
+//the stored and charted ranges are spans of time however often a device
+//reports.
+
+const ChartSeconds = DaysRange * 86400
+const RedisKeepSeconds = 2 * ChartSeconds //乘以2是用于冗余两倍的数据量
+
+var migratedSeries = struct {
+	sync.Mutex
+	keys map[string]bool
+}{keys: map[string]bool{}}
+
+//seriesKey converts a series still stored as a list by older versions into
+//the sorted set, once per key
+func seriesKey(key string) string {
+	migratedSeries.Lock()
+	defer migratedSeries.Unlock()
+	if migratedSeries.keys[key] {
+		return key
+	}
+
+	if kind, err := Redis().Type(key).Result(); err != nil {
+		return key
+	} else if kind == "list" {
+		list, err := Redis().LRange(key, 0, -1).Result()
+		if err != nil {
+			return key
+		}
+		tmp := key + "_migrate"
+		pipe := Redis().TxPipeline()
+		pipe.Del(tmp)
+		for _, str := range list {
+			data := make(map[string]interface{})
+			if json.Unmarshal([]byte(str), &data) == nil {
+				pipe.ZAdd(tmp, redis.Z{Score: uploadAddTime(data), Member: str})
+			}
+		}
+		pipe.Rename(tmp, key)
+		if _, err := pipe.Exec(); err != nil {
+			return key
+		}
+	}
+	migratedSeries.keys[key] = true
+	return key
+}
+
+//storeReading adds a reading to its series and drops the ones older than
+//RedisKeepSeconds
+func storeReading(name string, data map[string]interface{}) error {
+	saveStr, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	key := seriesKey(RedisDataKeyPrefix + name)
+	oldest := strconv.FormatInt(time.Now().Unix()-RedisKeepSeconds, 10)
+
+	pipe := Redis().TxPipeline()
+	pipe.ZAdd(key, redis.Z{Score: uploadAddTime(data), Member: string(saveStr)})
+	pipe.ZRemRangeByScore(key, "-inf", "("+oldest)
+	_, err = pipe.Exec()
+	return err
+}
+
+func decodeReadings(list []string) []map[string]interface{} {
+	readings := make([]map[string]interface{}, 0, len(list))
+	for _, jsonStr := range list {
+		var jsonO = make(map[string]interface{})
+		if json.Unmarshal([]byte(jsonStr), &jsonO) == nil {
+			readings = append(readings, jsonO)
+		}
+	}
+	return readings
+}
+
+//readingsSince returns the readings of a series key with from <= add_time,
+//ordered by add_time
+func readingsSince(key string, from float64) []map[string]interface{} {
+	list, err := Redis().ZRangeByScore(seriesKey(key), redis.ZRangeBy{
+		Min: strconv.FormatFloat(from, 'f', -1, 64),
+		Max: "+inf",
+	}).Result()
+	if err != nil {
+		return nil
+	}
+	return decodeReadings(list)
+}
+
+//latestReadings returns the latest count readings of a series key, ordered
+//by add_time
+func latestReadings(key string, count int64) []map[string]interface{} {
+	list, err := Redis().ZRevRange(seriesKey(key), 0, count-1).Result()
+	if err != nil {
+		return nil
+	}
+	for i, j := 0, len(list)-1; i < j; i, j = i+1, j-1 {
+		list[i], list[j] = list[j], list[i]
+	}
+	return decodeReadings(list)
+}
+
+//storedReadings returns the charted range of a series
+func storedReadings(redisKey string) []map[string]interface{} {
+	return readingsSince(redisKey, float64(time.Now().Unix()-ChartSeconds))
+}
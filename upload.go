@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"time"
+)
+
+//prepareUpload fills in the defaults of a single uploaded reading and
+//validates it. It returns the chip the reading belongs to.
+func prepareUpload(data map[string]interface{}) (string, error) {
+	return prepareReading(data, 0)
+}
+
+//prepareReading is prepareUpload for a reading sent at the device time
+//sentAt, see checkClockSkew. The skew is not checked if sentAt is 0.
+func prepareReading(data map[string]interface{}, sentAt float64) (string, error) {
+	chip := uploadChip(data)
+	data["chip"] = chip
+
+	if addTime, ok := data["add_time"]; !ok {
+		data["add_time"] = time.Now().Unix()
+		sentAt = 0
+	} else if _, ok := addTime.(float64); !ok {
+		return chip, &uploadError{
+			Status:  422,
+			Message: "schema validation failed",
+			Chip:    chip,
+			Fields:  []fieldError{{Field: "add_time", Error: "expected number", Value: addTime}},
+		}
+	}
+
+	if err := validateSchema(chip, data); err != nil {
+		return chip, err
+	}
+	//before the device status counts the reading once more
+	if seenReading(chip, data) {
+		return chip, errDuplicate
+	}
+	if sentAt == 0 {
+		touchDevice(chip, uploadAddTime(data))
+		return chip, nil
+	}
+	return chip, checkClockSkew(chip, data, sentAt)
+}
+
+func uploadChip(data map[string]interface{}) string {
+	if chip, ok := data["chip"].(string); ok {
+		return chip
+	}
+	return "undefined"
+}
+
+//storeUpload appends an uploaded reading to the chip's time series, see
+//saveData for duplicates
+func storeUpload(chip string, data map[string]interface{}) error {
+	if _, err := json.Marshal(data); err != nil {
+		return err
+	}
+	return saveData(chip, data)
+}
+
+func uploadAddTime(data map[string]interface{}) float64 {
+	switch v := data["add_time"].(type) {
+	case float64:
+		return v
+	case int64:
+		return float64(v)
+	}
+	return 0
+}
+
+type batchResult struct {
+	Index     int          `json:"index"`
+	Ok        bool         `json:"ok"`
+	Duplicate bool         `json:"duplicate,omitempty"`
+	Chip      string       `json:"chip,omitempty"`
+	AddTime   float64      `json:"add_time,omitempty"`
+	Error     string       `json:"error,omitempty"`
+	Fields    []fieldError `json:"fields,omitempty"`
+}
+
+//decodeBatch accepts either a JSON array of readings or a NDJSON stream
+//with one reading per line.
+func decodeBatch(b []byte) ([]interface{}, error) {
+	b = bytes.TrimSpace(b)
+	if len(b) > 0 && b[0] == '[' {
+		var items []interface{}
+		err := json.Unmarshal(b, &items)
+		return items, err
+	}
+
+	var items []interface{}
+	decoder := json.NewDecoder(bytes.NewReader(b))
+	for {
+		var item interface{}
+		err := decoder.Decode(&item)
+		if err == io.EOF {
+			return items, nil
+		}
+		if err != nil {
+			return items, err
+		}
+		items = append(items, item)
+	}
+}
+
+//sensorUploadBatch stores readings buffered by devices while they were
+//offline. Every reading is validated on its own and the accepted ones are
+//written in add_time order.
+func sensorUploadBatch(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+
+	b, err := ioutil.ReadAll(r.Body)
+	defer r.Body.Close()
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	items, err := decodeBatch(b)
+	if err != nil {
+		writeUploadError(w, &uploadError{Status: 400, Message: err.Error()})
+		return
+	}
+
+	type accepted struct {
+		index int
+		chip  string
+		data  map[string]interface{}
+	}
+	results := make([]batchResult, len(items))
+	var readings []accepted
+	for i, item := range items {
+		results[i].Index = i
+
+		data, ok := item.(map[string]interface{})
+		if !ok {
+			results[i].Error = "expected json object"
+			continue
+		}
+
+		//buffered readings are old by design, only an explicit send time tells the clock
+		chip, err := prepareReading(data, uploadSentAt(r, data))
+		if err == errDuplicate {
+			countUpload(chip, err)
+			results[i].Ok = true
+			results[i].Duplicate = true
+			results[i].Chip = chip
+			results[i].AddTime = uploadAddTime(data)
+			continue
+		}
+		if err != nil {
+			countUpload(chip, err)
+			results[i].Chip = chip
+			results[i].Error = err.Error()
+			if e, ok := err.(*uploadError); ok {
+				results[i].Fields = e.Fields
+			}
+			continue
+		}
+
+		results[i].Ok = true
+		results[i].Chip = chip
+		results[i].AddTime = uploadAddTime(data)
+		readings = append(readings, accepted{i, chip, data})
+	}
+
+	sort.SliceStable(readings, func(i, j int) bool {
+		return uploadAddTime(readings[i].data) < uploadAddTime(readings[j].data)
+	})
+
+	acceptedCount, duplicateCount := 0, 0
+	for _, result := range results {
+		if result.Duplicate {
+			duplicateCount++
+		}
+	}
+	for _, reading := range readings {
+		err := storeUpload(reading.chip, reading.data)
+		countUpload(reading.chip, err)
+		if err == errDuplicate {
+			results[reading.index].Duplicate = true
+			duplicateCount++
+			continue
+		}
+		if err != nil {
+			results[reading.index].Ok = false
+			results[reading.index].Error = err.Error()
+			if e, ok := err.(*uploadError); ok {
+				results[reading.index].Fields = e.Fields
+			}
+			continue
+		}
+		acceptedCount++
+	}
+
+	byteStr, _ := json.Marshal(map[string]interface{}{
+		"accepted":   acceptedCount,
+		"duplicates": duplicateCount,
+		"rejected":   len(items) - acceptedCount - duplicateCount,
+		"results":    results,
+	})
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(byteStr)
+	fmt.Println(time.Since(start), r.URL)
+}
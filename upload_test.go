@@ -0,0 +1,41 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDecodeBatch(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want []interface{}
+		err  bool
+	}{
+		{
+			"array",
+			` [{"chip":"a","temp":1},{"chip":"b","temp":2}] `,
+			[]interface{}{map[string]interface{}{"chip": "a", "temp": 1.0}, map[string]interface{}{"chip": "b", "temp": 2.0}},
+			false,
+		},
+		{
+			"ndjson",
+			"{\"chip\":\"a\",\"temp\":1}\n\n{\"chip\":\"b\",\"temp\":2}\n",
+			[]interface{}{map[string]interface{}{"chip": "a", "temp": 1.0}, map[string]interface{}{"chip": "b", "temp": 2.0}},
+			false,
+		},
+		{"not an object", "[1, \"two\"]", []interface{}{1.0, "two"}, false},
+		{"empty", "", nil, false},
+		{"malformed array", `[{"chip":"a"},`, nil, true},
+		{"malformed ndjson", "{\"chip\":\"a\"}\n{\"chip\":", []interface{}{map[string]interface{}{"chip": "a"}}, true},
+	}
+	for _, test := range tests {
+		got, err := decodeBatch([]byte(test.body))
+		if (err != nil) != test.err {
+			t.Errorf("%s: err = %v, want error %v", test.name, err, test.err)
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: decodeBatch = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+//virtualRedisKey resolves the series part of a variable, either a chart
+//(e.g. temperature_two) or a stored series (e.g. two)
+func virtualRedisKey(series string) string {
+	if item, ok := sensorSeries()[series]; ok {
+		redisKey, _ := item["redis_key"].(string)
+		return redisKey
+	}
+	if item, ok := derivedSeries()[series]; ok {
+		redisKey, _ := item["redis_key"].(string)
+		return redisKey
+	}
+	return RedisDataKeyPrefix + series
+}
+
+//alignedField puts the field of a series on the PointInterval grid, the
+//last reading of an interval wins
+func alignedField(redisKey string, field string) map[int64]float64 {
+	aligned := make(map[int64]float64)
+	for _, reading := range storedReadings(redisKey) {
+		if _, ok := reading[field].(float64); !ok {
+			continue
+		}
+		//flagged outliers make the interval null, see gridValues
+		value, ok := readingValue(reading, field)
+		if !ok {
+			value = math.NaN()
+		}
+		aligned[gridTime(uploadAddTime(reading))] = value
+	}
+	return aligned
+}
+
+//virtualSeries computes the virtual series at query time in the format of
+//sensorJson(). Intervals lacking any of the variables are gaps.
+func virtualSeries(opts seriesOptions) []map[string]interface{} {
+	var result []map[string]interface{}
+	for _, virtual := range Config().Virtual {
+		node, err := compiledExpr(virtual.Expr)
+		if err != nil {
+			continue
+		}
+
+		columns := make(map[string]map[int64]float64)
+		var start, end int64 = math.MaxInt64, 0
+		for _, name := range exprVars(node) {
+			if _, ok := columns[name]; ok {
+				continue
+			}
+			dot := strings.LastIndex(name, ".")
+			columns[name] = alignedField(virtualRedisKey(name[:dot]), name[dot+1:])
+			for t := range columns[name] {
+				if t < start {
+					start = t
+				}
+				if t > end {
+					end = t
+				}
+			}
+		}
+		if end == 0 {
+			continue
+		}
+
+		color := virtual.Color
+		if color == "" {
+			color = "#9966cc"
+		}
+		item := map[string]interface{}{
+			"name":           virtual.Name,
+			"series":         virtual.Name,
+			"point_interval": PointInterval,
+			"index":          "value",
+			"color":          color,
+			"order":          virtual.Order,
+			"unit":           virtual.Unit,
+			"max":            -9999.0,
+			"min":            99999.0,
+			"max_time":       0,
+			"min_time":       0,
+		}
+
+		points := make(map[int64]float64)
+		for t := start; t <= end; t += PointInterval {
+			value, err := node.eval(func(name string) (float64, bool) {
+				v, ok := columns[name][t]
+				return v, ok
+			})
+			if err != nil {
+				continue
+			}
+			points[t] = value
+			if value > item["max"].(float64) {
+				item["max"] = value
+				item["max_time"] = t
+			}
+			if value < item["min"].(float64) {
+				item["min"] = value
+				item["min_time"] = t
+			}
+		}
+		if len(points) == 0 {
+			continue
+		}
+		item["point_start"], item["value"] = gridValues(points, opts)
+		result = append(result, item)
+	}
+	return result
+}
+
+func validateVirtual(virtual VirtualSeries) error {
+	node, err := parseExpr(virtual.Expr)
+	if err != nil {
+		return err
+	}
+	for _, name := range exprVars(node) {
+		if dot := strings.LastIndex(name, "."); dot <= 0 || dot == len(name)-1 {
+			return fmt.Errorf("%s is not series.field", name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"text/template"
+	"time"
+)
+
+const DefaultWebhookTemplate = `{"alert":{{json .Rule.Name}},"series":{{json .Rule.Series}},"field":{{json .Rule.Field}},` +
+	`"severity":{{json .Rule.Severity}},"status":{{json .Status}},"from":{{json .From}},"to":{{json .To}},` +
+	`"value":{{json .Value}},"time":{{json .Time}}}`
+
+var webhookFuncs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		byteStr, err := json.Marshal(v)
+		return string(byteStr), err
+	},
+	"time": func(t float64) string {
+		return time.Unix(int64(t), 0).Format(time.RFC3339)
+	},
+}
+
+//webhookNotifier POSTs alert transitions rendered with a Go template. The
+//body is signed with HMAC-SHA256 of the secret in X-GoSensor-Signature.
+type webhookNotifier struct {
+	conf WebhookConfig
+}
+
+func (n webhookNotifier) Name() string {
+	return "webhook:" + n.conf.Name
+}
+
+func (n webhookNotifier) Retries() int {
+	return n.conf.Retries
+}
+
+func (n webhookNotifier) body(transition AlertTransition) ([]byte, error) {
+	text := n.conf.Template
+	if text == "" {
+		text = DefaultWebhookTemplate
+	}
+	tmpl, err := template.New(n.conf.Name).Funcs(webhookFuncs).Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	var b bytes.Buffer
+	if err := tmpl.Execute(&b, transition); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+func webhookSignature(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func (n webhookNotifier) Notify(transition AlertTransition) error {
+	body, err := n.body(transition)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", n.conf.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	contentType := n.conf.ContentType
+	if contentType == "" {
+		contentType = "application/json"
+	}
+	req.Header.Set("Content-Type", contentType)
+	for k, v := range n.conf.Headers {
+		req.Header.Set(k, v)
+	}
+	if n.conf.Secret != "" {
+		req.Header.Set("X-GoSensor-Signature", webhookSignature(n.conf.Secret, body))
+	}
+
+	client := http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		message, _ := ioutil.ReadAll(resp.Body)
+		return errors.New(resp.Status + " " + string(message))
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestWebhookNotify(t *testing.T) {
+	var received *http.Request
+	var body []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r
+		body, _ = ioutil.ReadAll(r.Body)
+	}))
+	defer server.Close()
+
+	n := webhookNotifier{WebhookConfig{
+		Name:    "test",
+		URL:     server.URL,
+		Headers: map[string]string{"X-Team": "ops"},
+		Secret:  "s3cret",
+	}}
+	transition := AlertTransition{
+		Rule:  AlertRule{Name: "hot", Series: "room", Field: "temp", Severity: "critical"},
+		From:  AlertPending,
+		To:    AlertFiring,
+		Value: 31.5,
+		Time:  1600000000,
+	}
+	if err := n.Notify(transition); err != nil {
+		t.Fatal(err)
+	}
+
+	if received.Method != "POST" || received.Header.Get("Content-Type") != "application/json" || received.Header.Get("X-Team") != "ops" {
+		t.Errorf("request = %s %v", received.Method, received.Header)
+	}
+	if got, want := received.Header.Get("X-GoSensor-Signature"), webhookSignature("s3cret", body); got != want {
+		t.Errorf("signature = %q, want %q", got, want)
+	}
+	if !strings.HasPrefix(received.Header.Get("X-GoSensor-Signature"), "sha256=") {
+		t.Errorf("signature = %q, want sha256=<hex>", received.Header.Get("X-GoSensor-Signature"))
+	}
+
+	var payload map[string]interface{}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		t.Fatalf("default template is not JSON: %s\n%s", err, body)
+	}
+	want := map[string]interface{}{
+		"alert": "hot", "series": "room", "field": "temp", "severity": "critical",
+		"status": "firing", "from": "pending", "to": "firing", "value": 31.5, "time": 1600000000.0,
+	}
+	for k, v := range want {
+		if payload[k] != v {
+			t.Errorf("body %s = %v, want %v", k, payload[k], v)
+		}
+	}
+}
+
+func TestWebhookTemplate(t *testing.T) {
+	var body []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ = ioutil.ReadAll(r.Body)
+	}))
+	defer server.Close()
+
+	n := webhookNotifier{WebhookConfig{
+		URL:         server.URL,
+		ContentType: "text/plain",
+		Template:    "{{.Rule.Name}} is {{.Status}} at {{time .Time}}",
+	}}
+	transition := AlertTransition{Rule: AlertRule{Name: "hot"}, From: AlertFiring, To: AlertInactive, Time: 0}
+	if err := n.Notify(transition); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(body), "hot is resolved at 1970-01-01T") {
+		t.Errorf("body = %q", body)
+	}
+}
+
+func TestWebhookNotifyError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	n := webhookNotifier{WebhookConfig{URL: server.URL}}
+	err := n.Notify(AlertTransition{To: AlertFiring})
+	if err == nil || !strings.Contains(err.Error(), "503") || !strings.Contains(err.Error(), "unavailable") {
+		t.Errorf("Notify = %v, want the status and body", err)
+	}
+
+	n.conf.Template = "{{.Missing}}"
+	if err := n.Notify(AlertTransition{}); err == nil {
+		t.Error("Notify with a broken template did not fail")
+	}
+}